@@ -1,6 +1,7 @@
 package game
 
 import (
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"math/rand"
@@ -87,7 +88,7 @@ func (g *Game) AddPlayer(player *Player) (*Player, error) {
 		return player, errors.New("player ID is empty")
 	}
 	if player.Nick == "" || len(player.Nick) > 50 {
-		return player, errors.New("invalid nickname len=%d", len(player.Nick))
+		return player, fmt.Errorf("invalid nickname len=%d", len(player.Nick))
 	}
 	g.mux.Lock()
 	defer g.mux.Unlock()
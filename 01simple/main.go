@@ -1,30 +1,40 @@
 package main
 
 import (
+	"bytes"
+	"embed"
+	"flag"
 	"fmt"
-	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 	"github.com/bukind/webtests/01simple/game"
 	"github.com/bukind/webtests/filefinder"
 	"github.com/bukind/webtests/logwrap"
+	"github.com/bukind/webtests/middleware"
+	"github.com/bukind/webtests/server"
+	"github.com/bukind/webtests/tmpl"
 )
 
+// content is the fallback for the files not found on disk.
+//go:embed templates static
+var content embed.FS
+
 var (
-	ff = filefinder.New(os.ExpandEnv("${GOPATH}/src/github.com/bukind/webtests/01simple"), "01simple", ".")
-	hlog         = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
-	notFoundTmpl = templateMust("templates/notfound.html")
-	joinTmpl = templateMust("templates/join.html")
-	failedToJoinTmpl = templateMust("templates/failed_to_join.html")
-	startTmpl = templateMust("templates/start.html")
+	ff = filefinder.NewRoots(
+		filefinder.Env("WEBTESTS_ROOT", "01simple"),
+		filefinder.ModuleRoot("01simple"),
+		filefinder.Executable(),
+		filefinder.Dir("."),
+		filefinder.FS("embedded", content),
+	)
+	hlog  = log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
+	pages *tmpl.Registry
 )
 
-func templateMust(files ...string) *template.Template {
-	return template.Must(template.ParseFiles(ff.Must(files...)...))
-}
-
 type Page struct {
 	Req  *http.Request
 	Vals map[string]string
@@ -46,9 +56,20 @@ func (p *Page) Val(key string) string {
 	return p.Req.FormValue(key)
 }
 
+// render sends the page with the status, or a 500 if the page fails.
+func render(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	var buf bytes.Buffer
+	if err := pages.Execute(&buf, name, data); err != nil {
+		logwrap.Logger(r.Context()).Printf("failed to render %s: %v", name, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
 func pageNotFound(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotFound)
-	notFoundTmpl.Execute(w, r.URL.Path)
+	render(w, r, http.StatusNotFound, "templates/notfound.html", r.URL.Path)
 }
 
 //
@@ -59,38 +80,39 @@ func pageNotFound(w http.ResponseWriter, r *http.Request) {
 // 3. in game: choosing numbers
 // 4. end game
 func main() {
+	harFile := flag.String("har", "", "record the traffic into this HAR file")
+	traceFile := flag.String("trace", "", "append the spans to this file in OTLP-JSON format")
+	auditFile := flag.String("audit", "", "append the joins to this hash-chained audit log")
+	dev := flag.Bool("dev", false, "re-parse the changed templates on every request")
+	debugAddr := flag.String("debug-addr", "", "serve /debug/requests, /metrics and /debug/audit on this address, e.g. localhost:6060")
+	cfg := server.Defaults("01simple", ":9999")
+	cfg.Logger = hlog
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	pages = tmpl.New(ff, tmpl.WithBase("templates/base.html"), tmpl.Dev(*dev)).Must(
+		"templates/notfound.html",
+		"templates/join.html",
+		"templates/failed_to_join.html",
+		"templates/start.html",
+	)
 	gm := game.NewGame()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/join.html", func(w http.ResponseWriter, r *http.Request) {
-		joinTmpl.Execute(w, game.Player{
-			Id:   game.NewID(),
-			Nick: r.FromValue("nickname"),
-		})
+		render(w, r, http.StatusOK, "templates/join.html", page(r).Set("id", game.NewID().String()))
 	})
 	mux.HandleFunc("/start.html", func(w http.ResponseWriter, r *http.Request) {
 		p, err := gm.AddPlayer(game.NewPlayer(game.ID(r.FormValue("id")), r.FormValue("nickname")))
+		logwrap.Logger(r.Context()).Printf("game %v add -> %v, %v", gm, p, err)
 		if err != nil {
-			failPage := struct {
-				P   *Player
-				Msg string
-			}{
-				P: p,
-				Msg: err.Error(),
-			}
-			failedToJoinTmpl.Execute(w, failPage)
+			// The audit log tells the failed joins by the status.
+			render(w, r, http.StatusConflict, "templates/failed_to_join.html", page(r).Set("error", err.Error()))
 			return
 		}
-		hlog.Printf("game %v add -> %v, %v", gm, p, err)
-		page := struct {
-			Id       game.ID
-			Nickname string
-			Num      int
-		}{
-			Id:       id,
-			Nickname: nickname,
-			Num:      p.Num
-		}
-		startTmpl.Execute(w, p)
+		render(w, r, http.StatusOK, "templates/start.html", page(r).
+			Set("id", p.Id.String()).
+			Set("nickname", p.Nick).
+			Set("num", strconv.Itoa(p.Num)))
 	})
 	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		pageNotFound(w, r)
@@ -103,19 +125,74 @@ func main() {
 		http.Redirect(w, r, "/join.html", http.StatusFound)
 	})
 
-	static := ff.Must("static")[0]
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(static))))
+	// The local static files are served on top of the embedded ones.
+	static, err := fs.Sub(ff.Overlay(), "static")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to find the static files:", err)
+		os.Exit(1)
+	}
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+
+	// The debug pages show the clients, so they are not on the public mux.
+	debug := http.NewServeMux()
+	tracker := logwrap.NewTracker(2*time.Second, hlog)
+	debug.Handle("/debug/requests", tracker)
+	metrics := logwrap.NewMetrics()
+	debug.Handle("/metrics", metrics)
 
-	server := &http.Server{
-		Addr:           ":9999",
-		Handler:        logwrap.Handler(mux, hlog),
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+	// The server's own middlewares, the outermost first.
+	var inner []middleware.Middleware
+	if *traceFile != "" {
+		f, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to open the trace file:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		inner = append(inner, logwrap.NewTracer("01simple", f).Handler)
+	}
+	if *harFile != "" {
+		har := logwrap.NewHARRecorder(*harFile, 1<<20)
+		defer func() {
+			if err := har.Close(); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to save the HAR file:", err)
+			}
+		}()
+		inner = append(inner, har.Handler)
+	}
+	if *auditFile != "" {
+		audit, err := logwrap.OpenAuditLog(*auditFile, hlog, "id", "nickname")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to open the audit log:", err)
+			os.Exit(1)
+		}
+		defer audit.Close()
+		// E.g. /debug/audit?field=id&value=PLAYERID on -debug-addr only,
+		// the player IDs are the secrets of the players.
+		debug.Handle("/debug/audit", audit)
+		inner = append(inner, audit.Handler)
+	}
+	inner = append(inner, metrics.Handler, tracker.Handler)
+
+	handler := middleware.Config{
+		Log:      []logwrap.Option{logwrap.WithLogger(hlog), logwrap.WithExcludePrefix("/static/", "/favicon.ico")},
+		Logger:   hlog,
+		Recover:  true,
+		Compress: true,
+		Security: &middleware.DefaultSecurityHeaders,
+		Inner:    inner,
+	}.Handler(mux)
+	if *debugAddr != "" {
+		dcfg := cfg
+		dcfg.Name = "01simple debug"
+		dcfg.Addr = *debugAddr
+		go func() {
+			if err := dcfg.ListenAndServe(debug); err != nil {
+				hlog.Println("failed to serve the debug pages:", err)
+			}
+		}()
 	}
-	defer server.Close()
-	hlog.Printf("Starting the server to listen on %s", server.Addr)
-	if err := server.ListenAndServe(); err != nil {
+	if err := cfg.ListenAndServe(handler); err != nil {
 		fmt.Fprintln(os.Stderr, "failed to serve http:", err)
 		os.Exit(1)
 	}
@@ -1,17 +1,32 @@
 package main
 
 import (
+	"embed"
 	"fmt"
 	"html/template"
 	"os"
 	"github.com/bukind/webtests/filefinder"
 )
 
+//go:embed templates
+var content embed.FS
+
 var (
-	ff = filefinder.New(os.ExpandEnv("${GOPATH}/src/github.com/bukind/webtests/03websock"), "03websock", ".")
-	helloTmpl = template.Must(template.ParseFiles(ff.Must("templates/hello.html")...))
+	ff = filefinder.NewRoots(
+		filefinder.Env("WEBTESTS_ROOT", "03websock"),
+		filefinder.ModuleRoot("03websock"),
+		filefinder.Executable(),
+		filefinder.Dir("."),
+		filefinder.FS("embedded", content),
+	)
+	helloTmpl = templateMust("templates/hello.html")
 )
 
+func templateMust(files ...string) *template.Template {
+	fsys, names := ff.MustFS(files...)
+	return template.Must(template.ParseFS(fsys, names...))
+}
+
 type Page struct {
 	Title string
 }
@@ -3,19 +3,25 @@
 // Usage:
 //
 // $ cd DIRTOEXPOSE
-// $ 04static [--port PORT]
+// $ 04static [--port PORT | --addr ADDR] [--log-format text|json|common|combined]
+//
+// With a format other than text only the request records go to the log,
+// the server messages go to stderr.
 package main
 
 import (
 	"flag"
 	"fmt"
 	"github.com/bukind/webtests/logwrap"
+	"github.com/bukind/webtests/middleware"
+	"github.com/bukind/webtests/server"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
-	"time"
 )
 
 const defaultPort = 9988
@@ -25,9 +31,21 @@ func main() {
 	var port int
 	flag.IntVar(&port, "port", 0, "port to listen to")
 	verbose := flag.Bool("verbose", false, "verbose logger")
+	logFormat := flag.String("log-format", "text", "log format: text, json, common or combined")
+	metricsPath := flag.String("metrics", "", "path to serve Prometheus metrics at, e.g. /metrics")
+	traceFile := flag.String("trace", "", "append the spans to this file in OTLP-JSON format")
+	compress := flag.Bool("gzip", true, "gzip the compressible files")
+	logFile := flag.String("log-file", "", "write the log to this file instead of stdout, reopen it on SIGHUP")
+	var rotate logwrap.RotateOptions
+	flag.Int64Var(&rotate.MaxSize, "log-max-size", 100<<20, "rotate the log file when it grows bigger, in bytes")
+	flag.DurationVar(&rotate.MaxAge, "log-max-age", 0, "rotate the log file when it gets older, e.g. 24h")
+	flag.IntVar(&rotate.MaxBackups, "log-backups", 5, "number of rotated log files to keep, 0 keeps all")
+	flag.BoolVar(&rotate.Compress, "log-compress", false, "gzip the rotated log files")
+	cfg := server.Defaults("04static", "")
+	cfg.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	if port == 0 {
+	if port == 0 && cfg.Addr == "" {
 		if m := portRe.FindStringSubmatch(os.Args[0]); len(m) > 1 {
 			var err error
 			port, err = strconv.Atoi(m[1])
@@ -41,23 +59,71 @@ func main() {
 		}
 	}
 
-	hlog := log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
+	var out io.Writer = os.Stdout
+	if *logFile != "" {
+		rf, err := logwrap.OpenRotatingFile(*logFile, rotate)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to open the log file:", err)
+			os.Exit(1)
+		}
+		defer rf.Close()
+		rf.ReopenOnSignal()
+		out = rf
+	}
+	// The records of the other formats are parsed by tools, so the banner,
+	// the server errors and the panics go to stderr for them.
+	var hout io.Writer = out
+	if *logFormat != "text" {
+		hout = os.Stderr
+	}
+	hlog := log.New(hout, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
+	cfg.Logger = hlog
+	if cfg.Addr == "" {
+		cfg.Addr = fmt.Sprintf(":%d", port)
+	}
 	mux := http.NewServeMux()
 	mux.Handle("/", http.FileServer(http.Dir("")))
-	handler := logwrap.Handler(mux, hlog)
-	if *verbose {
-		handler = logwrap.VerboseHandler(mux, hlog)
+	var inner []middleware.Middleware
+	if *traceFile != "" {
+		f, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to open the trace file:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		inner = append(inner, logwrap.NewTracer("04static", f).Handler)
+	}
+	if *metricsPath != "" {
+		metrics := logwrap.NewMetrics()
+		mux.Handle(*metricsPath, metrics)
+		inner = append(inner, metrics.Handler)
 	}
-	server := &http.Server{
-		Addr:           fmt.Sprintf(":%d", port),
-		Handler:        handler,
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+	var opts []logwrap.Option
+	switch *logFormat {
+	case "text":
+		opts = append(opts, logwrap.WithLogger(hlog))
+	case "json":
+		opts = append(opts, logwrap.WithSlog(slog.New(slog.NewJSONHandler(out, nil))))
+	case "common":
+		opts = append(opts, logwrap.WithAccessLog(out, logwrap.CommonLog))
+	case "combined":
+		opts = append(opts, logwrap.WithAccessLog(out, logwrap.CombinedLog))
+	default:
+		fmt.Fprintln(os.Stderr, "unknown log format:", *logFormat)
+		os.Exit(1)
+	}
+	if *verbose {
+		opts = append(opts, logwrap.Verbose())
 	}
-	defer server.Close()
-	hlog.Printf("Starting the server to listen on %s", server.Addr)
-	if err := server.ListenAndServe(); err != nil {
+	handler := middleware.Config{
+		Log:      opts,
+		Logger:   hlog,
+		Recover:  true,
+		Compress: *compress,
+		Security: &middleware.DefaultSecurityHeaders,
+		Inner:    inner,
+	}.Handler(mux)
+	if err := cfg.ListenAndServe(handler); err != nil {
 		fmt.Fprintln(os.Stderr, "failed to serve http:", err)
 		os.Exit(1)
 	}
@@ -1,22 +1,35 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
-	"strings"
+	"os"
+
+	"github.com/bukind/webtests/logwrap"
+	"github.com/bukind/webtests/middleware"
+	"github.com/bukind/webtests/server"
 )
 
 func main() {
-	fs := http.FileServer(http.Dir("assets"))
-	err := http.ListenAndServe(":9090", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+	cfg := server.Defaults("09wa", ":9090")
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	hlog := log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
+	cfg.Logger = hlog
+	handler := middleware.Config{
+		Log:      []logwrap.Option{logwrap.WithLogger(hlog), logwrap.WithExcludePrefix("/favicon.ico")},
+		Logger:   hlog,
+		Recover:  true,
+		Compress: true,
+		Security: &middleware.DefaultSecurityHeaders,
 		// Remove when dev is done.
-		resp.Header().Add("Cache-Control", "no-cache")
-		if strings.HasSuffix(req.URL.Path, ".wasm") {
-			resp.Header().Set("content-type", "application/wasm")
-		}
-		fs.ServeHTTP(resp, req)
-	}))
-	if err != nil {
+		Cache: []middleware.CacheRule{{CacheControl: "no-cache"}},
+		Types: map[string]string{".wasm": "application/wasm"},
+	}.Handler(http.FileServer(http.Dir("assets")))
+	if err := cfg.ListenAndServe(handler); err != nil {
 		fmt.Println("Failed to start server", err)
 		return
 	}
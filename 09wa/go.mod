@@ -1,5 +1,10 @@
 module github.com/bukind/webtests/09wa
 
-go 1.19
+go 1.23
 
-require github.com/bukind/wasm v0.0.2
+require (
+	github.com/bukind/wasm v0.0.2
+	github.com/bukind/webtests v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/bukind/webtests => ../
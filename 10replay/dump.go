@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	// logTimeRe matches the date and time logged with log.Ldate|log.Ltime.
+	logTimeRe = regexp.MustCompile(`^(\d{4}/\d\d/\d\d \d\d:\d\d:\d\d(\.\d+)?) `)
+	// markRe matches any line logged about a request.
+	markRe = regexp.MustCompile(`\b(req|rsp)#\S+ `)
+	// dumpRe matches the start of a verbose request dump.
+	dumpRe = regexp.MustCompile(`\breq#(\S+) follows:$`)
+	// reqRe matches a plain request line.
+	reqRe = regexp.MustCompile(`\breq#(\S+) ([A-Z]+) (HTTP/\S+) (\S+)$`)
+	// rspRe matches a response line.
+	rspRe = regexp.MustCompile(`\brsp#(\S+) (\d{3}) `)
+)
+
+const truncatedMark = "\n... (truncated)"
+
+// parseLog reads the requests logged by logwrap handlers with a log.Logger.
+// The statuses are taken from the rsp#N lines: a request gets the status
+// of the next rsp#N line with its ID.  The IDs are not unique in a log
+// spanning restarts, so a request superseded by another one with the same
+// ID before its rsp#N line is left without a status.
+func parseLog(b []byte) ([]*entry, error) {
+	var entries []*entry
+	pending := make(map[string]*entry) // the requests waiting for rsp#N by ID
+	var first time.Time
+	var dump *entry // the entry whose dump is being read
+	var text []byte // the text of the dump so far
+	finish := func() {
+		if dump == nil {
+			return
+		}
+		if e, err := parseDump(dump, text); err == nil {
+			entries = append(entries, e)
+		}
+		dump, text = nil, nil
+	}
+
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	for _, line := range lines {
+		if dump != nil && !markRe.Match(line) {
+			text = append(text, line...)
+			continue
+		}
+		finish()
+		var offset time.Duration
+		if m := logTimeRe.FindSubmatch(line); m != nil {
+			if t, err := time.Parse("2006/01/02 15:04:05.999999", string(m[1])); err == nil {
+				if first.IsZero() {
+					first = t
+				}
+				offset = t.Sub(first)
+			}
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if m := dumpRe.FindSubmatch(line); m != nil {
+			dump = &entry{id: string(m[1]), offset: offset}
+			pending[dump.id] = dump
+			continue
+		}
+		if m := reqRe.FindSubmatch(line); m != nil {
+			e := &entry{
+				id:     string(m[1]),
+				offset: offset,
+				method: string(m[2]),
+				uri:    string(m[4]),
+				header: make(http.Header),
+			}
+			entries = append(entries, e)
+			pending[e.id] = e
+			continue
+		}
+		if m := rspRe.FindSubmatch(line); m != nil {
+			if e, ok := pending[string(m[1])]; ok {
+				e.status, _ = strconv.Atoi(string(m[2]))
+				delete(pending, e.id)
+			}
+		}
+	}
+	finish()
+	return entries, nil
+}
+
+// parseDump fills e from the text of httputil.DumpRequest.
+func parseDump(e *entry, text []byte) (*entry, error) {
+	// The logger adds a newline after the dump unless it ends with one,
+	// as the dump of the request without a body does.
+	if !bytes.HasSuffix(text, []byte("\r\n")) {
+		text = bytes.TrimSuffix(text, []byte("\n"))
+	}
+	text = bytes.TrimSuffix(text, []byte(truncatedMark))
+	br := bufio.NewReader(bytes.NewReader(text))
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	// The body is whatever follows the headers: it may be truncated,
+	// so Content-Length is not to be trusted.
+	body, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	e.method = req.Method
+	e.uri = req.RequestURI
+	e.header = req.Header
+	e.body = body
+	return e, nil
+}
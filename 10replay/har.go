@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/bukind/webtests/logwrap"
+)
+
+// parseHAR reads the entries of an HTTP Archive.
+func parseHAR(b []byte) ([]*entry, error) {
+	var har logwrap.HAR
+	if err := json.Unmarshal(b, &har); err != nil {
+		return nil, err
+	}
+	var entries []*entry
+	for i, he := range har.Log.Entries {
+		u, err := url.Parse(he.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("entry #%d: %v", i, err)
+		}
+		e := &entry{
+			id:     he.ID,
+			offset: he.StartedDateTime.Sub(har.Log.Entries[0].StartedDateTime),
+			method: he.Request.Method,
+			uri:    u.RequestURI(),
+			header: make(http.Header),
+			status: he.Response.Status,
+		}
+		if e.id == "" {
+			e.id = strconv.Itoa(i)
+		}
+		for _, h := range he.Request.Headers {
+			// HTTP/2 pseudo-headers recorded by browsers are not headers.
+			if len(h.Name) > 0 && h.Name[0] != ':' {
+				e.header.Add(h.Name, h.Value)
+			}
+		}
+		if pd := he.Request.PostData; pd != nil {
+			e.body = []byte(pd.Text)
+			if pd.MimeType != "" && e.header.Get("Content-Type") == "" {
+				e.header.Set("Content-Type", pd.MimeType)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
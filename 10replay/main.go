@@ -0,0 +1,180 @@
+// Package 10replay replays recorded traffic against a server and compares
+// the response statuses with the recorded ones.
+// The traffic is read from HAR files or from the logs of logwrap verbose handlers
+// (the req#N follows: blocks; plain req#N lines are replayed too).
+// Usage:
+//
+// $ 10replay [--target URL] [--speed X] [--send-redacted] FILE...
+//
+// With --speed 1 the requests are sent with the original relative timing,
+// 2 is twice as fast, and 0 sends them all at once.
+// The requests with the values masked by logwrap are skipped unless
+// --send-redacted is given, since the server is unlikely to accept them.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// entry is a recorded request with the status it got.
+type entry struct {
+	id     string
+	offset time.Duration // since the first recorded request
+	method string
+	uri    string // request URI: path and query
+	header http.Header
+	body   []byte
+	status int // 0 if unknown
+}
+
+// redactedMark is what logwrap puts instead of the masked values.
+const redactedMark = "REDACTED"
+
+// redacted reports whether some values of the request are masked.
+func (e *entry) redacted() bool {
+	if strings.Contains(e.uri, redactedMark) || bytes.Contains(e.body, []byte(redactedMark)) {
+		return true
+	}
+	for _, values := range e.header {
+		for _, v := range values {
+			if strings.Contains(v, redactedMark) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// result is the outcome of the replay of an entry.
+type result struct {
+	e      *entry
+	status int
+	err    error
+}
+
+// hopHeaders are not replayed, the client sets them itself.
+var hopHeaders = []string{"Host", "Content-Length", "Connection", "Transfer-Encoding", "Keep-Alive", "Upgrade", "Accept-Encoding"}
+
+func main() {
+	target := flag.String("target", "http://localhost:9999", "server to replay the traffic against")
+	speed := flag.Float64("speed", 1, "speed multiplier of the original timing, 0 for no delays")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of a single request")
+	sendRedacted := flag.Bool("send-redacted", false, "replay the requests with the masked values too")
+	flag.Parse()
+
+	base, err := url.Parse(*target)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		fmt.Fprintln(os.Stderr, "bad target URL:", *target)
+		os.Exit(1)
+	}
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "no recorded traffic given")
+		os.Exit(1)
+	}
+	var entries []*entry
+	skipped := 0
+	for _, name := range flag.Args() {
+		es, err := readFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		for _, e := range es {
+			if !*sendRedacted && e.redacted() {
+				skipped++
+				fmt.Printf("req#%s %s %s: skipped, it has %s values\n", e.id, e.method, e.uri, redactedMark)
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].offset < entries[j].offset })
+
+	client := &http.Client{
+		Timeout: *timeout,
+		// The redirects are compared, not followed.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	results := replay(client, base, entries, *speed)
+
+	diffs, errs := 0, 0
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			errs++
+			fmt.Printf("req#%s %s %s: %v\n", r.e.id, r.e.method, r.e.uri, r.err)
+		case r.e.status != 0 && r.status != r.e.status:
+			diffs++
+			fmt.Printf("req#%s %s %s: got status %d, want %d\n", r.e.id, r.e.method, r.e.uri, r.status, r.e.status)
+		}
+	}
+	fmt.Printf("%d requests replayed: %d ok, %d status diffs, %d errors, %d skipped\n", len(results), len(results)-diffs-errs, diffs, errs, skipped)
+	if diffs+errs > 0 {
+		os.Exit(1)
+	}
+}
+
+// readFile reads the entries from a HAR file or a logwrap log.
+func readFile(name string) ([]*entry, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' {
+		return parseHAR(b)
+	}
+	return parseLog(b)
+}
+
+// replay sends the requests keeping their relative timing divided by speed.
+// The results are in the order of entries.
+func replay(client *http.Client, base *url.URL, entries []*entry, speed float64) []result {
+	results := make([]result, len(entries))
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		if speed > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(e.offset) / speed))))
+		}
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			status, err := send(client, base, e)
+			results[i] = result{e, status, err}
+		}(i, e)
+	}
+	wg.Wait()
+	return results
+}
+
+func send(client *http.Client, base *url.URL, e *entry) (int, error) {
+	u, err := base.Parse(e.uri)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(e.method, u.String(), bytes.NewReader(e.body))
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range e.header {
+		req.Header[name] = values
+	}
+	for _, name := range hopHeaders {
+		req.Header.Del(name)
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	rsp.Body.Close()
+	return rsp.StatusCode, nil
+}
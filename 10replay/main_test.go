@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		file string
+		want []string // id method uri status redacted
+		body map[string]string
+	}{
+		{
+			file: "testdata/verbose.log",
+			want: []string{
+				"0 GET / 302 false",
+				"1 GET /join.html?nickname=frank 200 true",
+				"2 POST /start.html 200 true",
+				"3 POST /start.html 200 false",
+				"client-1 GET /missing.html 404 false",
+			},
+			body: map[string]string{
+				"0": "",
+				"2": "id=42&nickname=frank&password=REDACTED",
+				"3": "id=43&nickname=a-very-long-nickn",
+			},
+		},
+		{
+			file: "testdata/plain.log",
+			want: []string{
+				"0 GET / 302 false",
+				"1 GET /join.html?nickname=frank 200 false",
+				"2 POST /start.html 200 false",
+				"3 POST /start.html 200 false",
+				"client-1 GET /missing.html 404 false",
+				"5 GET /join.html 0 false",
+				"0 GET /missing.html 404 false",
+				"1 GET / 302 false",
+			},
+		},
+		{
+			file: "testdata/session.har",
+			want: []string{
+				"0 GET / 302 false",
+				"1 GET /join.html?nickname=frank 200 true",
+				"2 POST /start.html 200 true",
+				"3 POST /start.html 200 false",
+				"client-1 GET /missing.html 404 false",
+			},
+			body: map[string]string{
+				"3": "id=43&nickname=a-very-long-nickname-which-is-truncated",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			entries, err := readFile(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for i, e := range entries {
+				got = append(got, fmt.Sprintf("%s %s %s %d %t", e.id, e.method, e.uri, e.status, e.redacted()))
+				if i > 0 && e.offset < entries[i-1].offset {
+					t.Errorf("entry #%d is before the previous one", i)
+				}
+				if want, ok := tc.body[e.id]; ok && string(e.body) != want {
+					t.Errorf("req#%s: got body %q, want %q", e.id, e.body, want)
+				}
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("entry #%d: got %q, want %q", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, r.Method+" "+r.URL.RequestURI()+" "+r.Form.Encode())
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/join.html", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	base, _ := url.Parse(srv.URL)
+
+	entries, err := readFile("testdata/verbose.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := replay(srv.Client(), base, entries[3:], 0)
+	results = append(results, replay(&http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}, base, entries[:1], 0)...)
+	want := []struct {
+		status int
+		req    string
+	}{
+		{200, "POST /start.html id=43&nickname=a-very-long-nickn"},
+		{200, "GET /missing.html "},
+		{302, "GET / "},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		if r.err != nil || r.status != want[i].status {
+			t.Errorf("req#%s: got %d, %v, want %d", r.e.id, r.status, r.err, want[i].status)
+		}
+	}
+	for _, w := range want {
+		found := false
+		for _, g := range got {
+			found = found || g == w.req
+		}
+		if !found {
+			t.Errorf("the server got %q, want %q among them", got, w.req)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package filefinder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// Reason tells why a candidate file is rejected.
+type Reason string
+
+const (
+	ReasonMissing     Reason = "missing"
+	ReasonPermission  Reason = "permission denied"
+	ReasonNotRegular  Reason = "not a regular file"
+	ReasonUnavailable Reason = "root is unavailable"
+	ReasonError       Reason = "error"
+)
+
+// Probe is a rejected candidate: the file in the root, or the whole root
+// if File is empty.
+type Probe struct {
+	Root   Root
+	File   string
+	Reason Reason
+	Err    error // the underlying error, if any
+}
+
+func (p Probe) String() string {
+	s := p.Root.String()
+	if p.File != "" {
+		s += ": " + p.File
+	}
+	s += ": " + string(p.Reason)
+	if p.Err != nil && p.Reason != ReasonMissing {
+		s += ": " + p.Err.Error()
+	}
+	return s
+}
+
+// NotFoundError is returned if the files are not found in any root.
+// It matches fs.ErrNotExist with errors.Is.
+type NotFoundError struct {
+	Files  []string
+	Probes []Probe
+}
+
+func (e *NotFoundError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "files %v are not found", e.Files)
+	for _, p := range e.Probes {
+		b.WriteString("\n\t")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// Is reports whether target is fs.ErrNotExist.
+func (e *NotFoundError) Is(target error) bool {
+	return target == fs.ErrNotExist
+}
+
+// probe checks the file with the cleaned relative OS path name in root.
+// Regular files and directories are accepted.
+func probe(root Root, name string) (Probe, bool) {
+	fi, err := root.stat(name)
+	switch {
+	case err == nil && (fi.Mode().IsRegular() || fi.IsDir()):
+		return Probe{}, true
+	case err == nil:
+		return Probe{root, name, ReasonNotRegular, nil}, false
+	case errors.Is(err, fs.ErrNotExist):
+		return Probe{root, name, ReasonMissing, err}, false
+	case errors.Is(err, fs.ErrPermission):
+		return Probe{root, name, ReasonPermission, err}, false
+	}
+	return Probe{root, name, ReasonError, err}, false
+}
@@ -1,59 +1,156 @@
-// Package filefinder helps to find files in absolute or relative paths.
+// Package filefinder helps to find files in absolute or relative paths,
+// or in fs.FS roots such as embed.FS.
 package filefinder
 
 import (
 	"fmt"
-	"os"
+	"io/fs"
+	"path"
 	"path/filepath"
 )
 
-// Finder is a helper to find files in a slice of directories.
+// Finder is a helper to find files in a slice of roots.
 type Finder struct {
-	paths []string
+	roots   []Root
+	perFile bool // resolve every file independently
 }
 
-// New creates a new Finder.
+// New creates a new Finder of OS directories.
 func New(paths ...string) Finder {
 	if len(paths) == 0 {
 		paths = []string{"./"}
 	}
-	return Finder{paths}
+	var roots []Root
+	for _, path := range paths {
+		roots = append(roots, Dir(path))
+	}
+	return Finder{roots: roots}
+}
+
+// NewRoots creates a new Finder of roots in the order of priority.
+func NewRoots(roots ...Root) Finder {
+	if len(roots) == 0 {
+		roots = []Root{Dir(".")}
+	}
+	return Finder{roots: roots}
+}
+
+// Roots returns the roots of the finder.
+func (f Finder) Roots() []Root {
+	return f.roots
 }
 
 // Find searches for files in a set of directories.
 // All files should have relative names.
 // If a file is found in some directory all other files
 // should also exists in the same directory.
+// The directory must be on disk, use FindFS for fs.FS roots.
+// In the PerFile mode every file is searched independently.
 func (f Finder) Find(files ...string) ([]string, error) {
+	if f.perFile {
+		matches, err := f.FindEach(files...)
+		if err != nil {
+			return nil, err
+		}
+		var fps []string
+		for _, m := range matches {
+			if m.Path() == "" {
+				return nil, fmt.Errorf("file %q is found in %s which is not a directory on disk", m.Name, m.Root)
+			}
+			fps = append(fps, m.Path())
+		}
+		return fps, nil
+	}
+	root, names, err := f.find(files)
+	if err != nil {
+		return nil, err
+	}
+	if root.dir == "" {
+		return nil, fmt.Errorf("files %v are found in %s which is not a directory on disk", files, root)
+	}
+	var fps []string
+	for _, name := range names {
+		fps = append(fps, filepath.Join(root.dir, name))
+	}
+	return fps, nil
+}
+
+// FindFS searches for files like Find, but returns the file system of
+// the root they are found in and their slash-separated names in it,
+// as expected by template.ParseFS and http.FS.
+// In the PerFile mode the file system contains the found files only.
+func (f Finder) FindFS(files ...string) (fs.FS, []string, error) {
+	names, err := slashNames(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.perFile {
+		matches, err := f.FindEach(files...)
+		if err != nil {
+			return nil, nil, err
+		}
+		fsys := make(matchFS)
+		for _, m := range matches {
+			fsys[m.Name] = m.Root
+		}
+		return fsys, names, nil
+	}
+	root, names, err := f.find(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, name := range names {
+		names[i] = filepath.ToSlash(name)
+	}
+	return root.fsys, names, nil
+}
+
+func (f Finder) find(files []string) (Root, []string, error) {
 	var fps []string
 	for _, path := range files {
 		f := filepath.Clean(path)
 		if filepath.IsAbs(f) {
-			return nil, fmt.Errorf("file path %q is absolute", path)
+			return Root{}, nil, fmt.Errorf("file path %q is absolute", path)
 		}
 		fps = append(fps, f)
 	}
-	for _, path := range f.paths {
-		path = filepath.Clean(path)
-		fps, err := findAll(path, fps)
-		if !os.IsNotExist(err) {
-			return fps, err
+	var probes []Probe
+	for _, root := range f.roots {
+		if root.err != nil {
+			probes = append(probes, Probe{root, "", ReasonUnavailable, root.err})
+			continue
+		}
+		rejected := findAll(root, fps)
+		if len(rejected) == 0 {
+			return root, fps, nil
 		}
+		probes = append(probes, rejected...)
 	}
-	return nil, fmt.Errorf("files %v are not found in any of %v", files, f.paths)
+	return Root{}, nil, &NotFoundError{files, probes}
 }
 
-func findAll(dir string, files []string) ([]string, error) {
-	var fps []string
+// slashNames returns the valid slash-separated names of files.
+func slashNames(files []string) ([]string, error) {
+	var names []string
+	for _, file := range files {
+		name := path.Clean(filepath.ToSlash(file))
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("file path %q is not a valid relative path", file)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// findAll returns the rejected probes of files in root.
+func findAll(root Root, files []string) []Probe {
+	var rejected []Probe
 	for _, fp := range files {
-		fp = filepath.Join(dir, fp)
-		_, err := os.Stat(fp)
-		if err != nil {
-			return nil, err
+		if p, ok := probe(root, fp); !ok {
+			rejected = append(rejected, p)
 		}
-		fps = append(fps, fp)
 	}
-	return fps, nil
+	return rejected
 }
 
 // Must returns the list of found files, or panics if they are not found.
@@ -65,3 +162,12 @@ func (f Finder) Must(files ...string) []string {
 	}
 	return files
 }
+
+// MustFS is like FindFS, but panics if the files are not found.
+func (f Finder) MustFS(files ...string) (fs.FS, []string) {
+	fsys, names, e := f.FindFS(files...)
+	if e != nil {
+		panic(e)
+	}
+	return fsys, names
+}
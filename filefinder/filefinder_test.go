@@ -1,8 +1,14 @@
 package filefinder
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func equalLists(a, b []string) error {
@@ -75,3 +81,493 @@ func TestFind(t *testing.T) {
 		})
 	}
 }
+
+func TestFindFS(t *testing.T) {
+	embedded := fstest.MapFS{
+		"templates/a.html": {Data: []byte("a")},
+		"templates/b.html": {Data: []byte("b")},
+		"testfile.txt":     {Data: []byte("embedded")},
+	}
+	tests := []struct {
+		desc  string
+		roots []Root
+		files []string
+		ok    bool
+		want  []string
+		data  string // the content of the first file
+	}{
+		{
+			desc:  "found in fs.FS",
+			roots: []Root{Dir("./some-unknown-dir"), FS("embedded", embedded)},
+			files: []string{"templates/a.html", "./templates/b.html"},
+			ok:    true,
+			want:  []string{"templates/a.html", "templates/b.html"},
+			data:  "a",
+		},
+		{
+			desc:  "directory takes priority",
+			roots: []Root{Dir("testdata"), FS("embedded", embedded)},
+			files: []string{"testfile.txt"},
+			ok:    true,
+			want:  []string{"testfile.txt"},
+			data:  "",
+		},
+		{
+			desc:  "fs.FS takes priority",
+			roots: []Root{FS("embedded", embedded), Dir("testdata")},
+			files: []string{"testfile.txt"},
+			ok:    true,
+			want:  []string{"testfile.txt"},
+			data:  "embedded",
+		},
+		{
+			desc:  "fail if not all in one root",
+			roots: []Root{Dir("testdata"), FS("templates", fstest.MapFS{"templates/a.html": {}})},
+			files: []string{"testfile.txt", "templates/a.html"},
+			ok:    false,
+		},
+		{
+			desc:  "fail if outside of root",
+			roots: []Root{FS("embedded", embedded)},
+			files: []string{"../testfile.txt"},
+			ok:    false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			fsys, got, err := NewRoots(tc.roots...).FindFS(tc.files...)
+			if tc.ok != (err == nil) {
+				t.Fatalf("got %v, want %t", err, tc.ok)
+			}
+			if err != nil {
+				return
+			}
+			if err := equalLists(got, tc.want); err != nil {
+				t.Errorf("got %v, want %v => %v", got, tc.want, err)
+			}
+			data, err := fs.ReadFile(fsys, got[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.data != "" && string(data) != tc.data {
+				t.Errorf("got %q in %s, want %q", data, got[0], tc.data)
+			}
+		})
+	}
+}
+
+func TestFindNotOnDisk(t *testing.T) {
+	f := NewRoots(FS("embedded", fstest.MapFS{"x.txt": {}}))
+	if got, err := f.Find("x.txt"); err == nil {
+		t.Errorf("got %v, want an error", got)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	abs, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FILEFINDER_SET", abs)
+	t.Setenv("FILEFINDER_UNSET", "")
+	tests := []struct {
+		desc string
+		root Root
+		ok   bool
+		want string
+	}{
+		{
+			desc: "environment variable",
+			root: Env("FILEFINDER_SET", "mod"),
+			ok:   true,
+			want: filepath.Join(abs, "mod"),
+		},
+		{
+			desc: "unset environment variable",
+			root: Env("FILEFINDER_UNSET"),
+			ok:   false,
+		},
+		{
+			desc: "module root",
+			root: moduleRoot(filepath.Join(abs, "mod", "sub"), "sub"),
+			ok:   true,
+			want: filepath.Join(abs, "mod", "sub"),
+		},
+		{
+			desc: "no module root",
+			root: moduleRoot(t.TempDir()),
+			ok:   false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if tc.ok != (tc.root.Err() == nil) {
+				t.Fatalf("got %v, want %t", tc.root.Err(), tc.ok)
+			}
+			if tc.ok && tc.root.Path() != tc.want {
+				t.Errorf("got %q, want %q", tc.root.Path(), tc.want)
+			}
+		})
+	}
+
+	exe := Executable()
+	if _, err := os.Stat(exe.Path()); err != nil {
+		t.Errorf("executable root %v: %v", exe, err)
+	}
+	f := NewRoots(Env("FILEFINDER_UNSET"), Env("FILEFINDER_SET"))
+	if got, err := f.Find("testfile.txt"); err != nil || got[0] != filepath.Join(abs, "testfile.txt") {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
+
+func TestFindEach(t *testing.T) {
+	local := fstest.MapFS{
+		"templates/join.html": {Data: []byte("local")},
+	}
+	shipped := fstest.MapFS{
+		"templates/join.html":  {Data: []byte("shipped")},
+		"templates/start.html": {Data: []byte("shipped")},
+	}
+	f := NewRoots(FS("local", local), FS("shipped", shipped)).PerFile()
+	got, err := f.FindEach("templates/join.html", "templates/start.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"local", "shipped"}
+	for i, m := range got {
+		if m.Root.String() != want[i] {
+			t.Errorf("%s is found in %s, want %s", m.Name, m.Root, want[i])
+		}
+	}
+	if _, err := f.FindEach("templates/join.html", "templates/nope.html"); err == nil {
+		t.Error("no error for a missing file")
+	}
+
+	fsys, names, err := f.FindFS("templates/join.html", "templates/start.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil || string(data) != want[i] {
+			t.Errorf("got %q, %v for %s, want %q", data, err, name, want[i])
+		}
+	}
+
+	paths, err := New("testdata", "/etc").PerFile().Find("testfile.txt", "passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := equalLists(paths, []string{"testdata/testfile.txt", "/etc/passwd"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	local := fstest.MapFS{
+		"static/css/site.css": {Data: []byte("local")},
+		"templates/join.html": {Data: []byte("local")},
+		"templates/notes.txt": {Data: []byte("local")},
+	}
+	shipped := fstest.MapFS{
+		"static/site.css":         {},
+		"static/css/site.css":    {},
+		"static/css/dark/x.css": {},
+		"templates/join.html":    {},
+		"templates/start.html":    {},
+		"templates/sub/deep.html": {},
+	}
+	f := NewRoots(FS("local", local), Dir("./some-unknown-dir"), FS("shipped", shipped))
+	tests := []struct {
+		desc     string
+		patterns []string
+		ok       bool
+		want     []string // name@root
+	}{
+		{
+			desc:     "shadowing",
+			patterns: []string{"templates/*.html"},
+			ok:       true,
+			want:     []string{"templates/join.html@local", "templates/start.html@shipped"},
+		},
+		{
+			desc:     "double star",
+			patterns: []string{"static/**/*.css"},
+			ok:       true,
+			want:     []string{"static/css/dark/x.css@shipped", "static/css/site.css@local", "static/site.css@shipped"},
+		},
+		{
+			desc:     "several patterns",
+			patterns: []string{"templates/*.txt", "templates/**/deep.html"},
+			ok:       true,
+			want:     []string{"templates/notes.txt@local", "templates/sub/deep.html@shipped"},
+		},
+		{
+			desc:     "no match",
+			patterns: []string{"nowhere/*.html"},
+			ok:       true,
+		},
+		{
+			desc:     "bad pattern",
+			patterns: []string{"templates/[.html"},
+			ok:       false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			matches, err := f.Glob(tc.patterns...)
+			if tc.ok != (err == nil) {
+				t.Fatalf("got %v, want %t", err, tc.ok)
+			}
+			var got []string
+			for _, m := range matches {
+				got = append(got, m.Name+"@"+m.Root.String())
+			}
+			if err := equalLists(got, tc.want); err != nil {
+				t.Errorf("got %v, want %v => %v", got, tc.want, err)
+			}
+		})
+	}
+}
+
+// deniedFS denies access to every file.
+type deniedFS struct{}
+
+func (deniedFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+func TestNotFoundError(t *testing.T) {
+	t.Setenv("FILEFINDER_UNSET", "")
+	found := fstest.MapFS{"a.txt": {Data: []byte("found")}}
+	odd := fstest.MapFS{"a.txt": {Mode: fs.ModeNamedPipe}}
+
+	fsys, _, err := NewRoots(FS("denied", deniedFS{}), FS("found", found)).FindFS("a.txt")
+	if err != nil {
+		t.Fatalf("permission error did not fall through: %v", err)
+	}
+	if data, _ := fs.ReadFile(fsys, "a.txt"); string(data) != "found" {
+		t.Errorf("got %q, want %q", data, "found")
+	}
+
+	f := NewRoots(Env("FILEFINDER_UNSET"), FS("denied", deniedFS{}), FS("odd", odd), FS("found", found))
+	for _, mode := range []string{"all", "per file"} {
+		t.Run(mode, func(t *testing.T) {
+			var err error
+			if mode == "all" {
+				_, _, err = f.FindFS("a.txt", "b.txt")
+			} else {
+				_, err = f.FindEach("a.txt", "b.txt")
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("got %v, want fs.ErrNotExist", err)
+			}
+			var nf *NotFoundError
+			if !errors.As(err, &nf) {
+				t.Fatalf("got %T, want *NotFoundError", err)
+			}
+			var got []string
+			for _, p := range nf.Probes {
+				got = append(got, p.Root.String()+":"+p.File+":"+string(p.Reason))
+			}
+			want := []string{
+				"$FILEFINDER_UNSET::root is unavailable",
+				"denied:a.txt:permission denied",
+				"denied:b.txt:permission denied",
+				"odd:a.txt:not a regular file",
+				"odd:b.txt:missing",
+				"found:b.txt:missing",
+			}
+			if mode == "per file" {
+				// a.txt is found, only b.txt is probed everywhere.
+				want = []string{
+					"$FILEFINDER_UNSET::root is unavailable",
+					"denied:b.txt:permission denied",
+					"odd:b.txt:missing",
+					"found:b.txt:missing",
+				}
+			}
+			if err := equalLists(got, want); err != nil {
+				t.Errorf("got %v, want %v => %v", got, want, err)
+			}
+		})
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	t.Setenv("FILEFINDER_UNSET", "")
+	local := fstest.MapFS{
+		"static/site.css":    {Data: []byte("local")},
+		"static/custom.png":  {Data: []byte("custom")},
+		"static/img":         {Data: []byte("shadows the directory")},
+		"templates/base.txt": {Data: []byte("local")},
+	}
+	shipped := fstest.MapFS{
+		"static/site.css":  {Data: []byte("shipped")},
+		"static/app.js":    {Data: []byte("shipped")},
+		"static/img/x.png": {Data: []byte("shipped")},
+		"static/css/a.css": {Data: []byte("shipped")},
+		"templates/a.html": {Data: []byte("shipped")},
+	}
+	fsys := NewRoots(Env("FILEFINDER_UNSET"), FS("local", local), FS("shipped", shipped)).Overlay()
+	if err := fstest.TestFS(fsys, "static/site.css", "static/custom.png", "static/app.js", "static/css/a.css", "templates/a.html", "templates/base.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"static/site.css", "local"},
+		{"static/app.js", "shipped"},
+		{"static/img", "shadows the directory"},
+	}
+	for _, tc := range tests {
+		data, err := fs.ReadFile(fsys, tc.name)
+		if err != nil || string(data) != tc.want {
+			t.Errorf("got %q, %v for %s, want %q", data, err, tc.name, tc.want)
+		}
+	}
+
+	entries, err := fs.ReadDir(fsys, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{"app.js", "css", "custom.png", "img", "site.css"}
+	if err := equalLists(got, want); err != nil {
+		t.Errorf("got %v, want %v => %v", got, want, err)
+	}
+	if _, err := fsys.Open("static/nope.css"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestOverlayFileOnPath(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "static"), []byte("a binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	shipped := fstest.MapFS{"static/app.js": {Data: []byte("shipped")}}
+	fsys := NewRoots(Dir(bin), FS("shipped", shipped)).Overlay()
+	data, err := fs.ReadFile(fsys, "static/app.js")
+	if err != nil || string(data) != "shipped" {
+		t.Errorf("got %q, %v, want the shipped file", data, err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	local, shipped := t.TempDir(), t.TempDir()
+	write := func(dir, name, data string) {
+		t.Helper()
+		fp := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fp, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(shipped, "templates/a.html", "a")
+	write(shipped, "templates/b.html", "b")
+
+	w, err := NewRoots(Dir(local), Dir(shipped)).Watch(5*time.Millisecond, 20*time.Millisecond, "templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	tests := []struct {
+		desc   string
+		change func()
+		want   []string // name:kind:root
+	}{
+		{
+			desc:   "created",
+			change: func() { write(shipped, "templates/c.html", "c") },
+			want:   []string{"templates/c.html:created:" + shipped},
+		},
+		{
+			desc:   "shadowed",
+			change: func() { write(local, "templates/a.html", "local a") },
+			want:   []string{"templates/a.html:shadowed:" + local},
+		},
+		{
+			desc: "modified twice",
+			change: func() {
+				write(shipped, "templates/b.html", "bb")
+				time.Sleep(10 * time.Millisecond)
+				write(shipped, "templates/b.html", "bbb")
+			},
+			want: []string{"templates/b.html:modified:" + shipped},
+		},
+		{
+			desc:   "shadowing removed",
+			change: func() { os.Remove(filepath.Join(local, "templates/a.html")) },
+			want:   []string{"templates/a.html:modified:" + shipped},
+		},
+		{
+			desc: "removed and not matching",
+			change: func() {
+				os.Remove(filepath.Join(shipped, "templates/c.html"))
+				write(shipped, "templates/d.txt", "d")
+			},
+			want: []string{"templates/c.html:removed:" + shipped},
+		},
+	}
+	for _, tc := range tests {
+		tc.change()
+		var got []string
+		timeout := time.After(2 * time.Second)
+		for len(got) < len(tc.want) {
+			select {
+			case e := <-w.Events():
+				got = append(got, fmt.Sprintf("%s:%v:%v", e.Name, e.Kind, e.Root))
+			case <-timeout:
+				t.Fatalf("%s: got %v, want %v", tc.desc, got, tc.want)
+			}
+		}
+		select {
+		case e := <-w.Events():
+			got = append(got, fmt.Sprintf("%s:%v:%v", e.Name, e.Kind, e.Root))
+		case <-time.After(100 * time.Millisecond):
+		}
+		if err := equalLists(got, tc.want); err != nil {
+			t.Errorf("%s: got %v, want %v => %v", tc.desc, got, tc.want, err)
+		}
+	}
+
+	w.Close()
+	if _, ok := <-w.Events(); ok {
+		t.Error("events are not closed")
+	}
+}
+
+func TestWatchArgs(t *testing.T) {
+	tests := []struct {
+		desc     string
+		interval time.Duration
+		debounce time.Duration
+		ok       bool
+	}{
+		{"valid", time.Second, 0, true},
+		{"zero interval", 0, 0, false},
+		{"negative interval", -time.Second, 0, false},
+		{"negative debounce", time.Second, -time.Second, false},
+	}
+	for _, tc := range tests {
+		w, err := NewRoots(Dir(t.TempDir())).Watch(tc.interval, tc.debounce, "*.html")
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v, want ok=%v", tc.desc, err, tc.ok)
+		}
+		if w != nil {
+			w.Close()
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package filefinder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Match is a file found in a root.
+type Match struct {
+	Name string // slash-separated name in the root
+	Root Root   // the root which supplied the file
+
+	index int // of the root in the finder
+}
+
+// Path returns the OS path of the file, or "" if the root is not on disk.
+func (m Match) Path() string {
+	if m.Root.dir == "" {
+		return ""
+	}
+	return filepath.Join(m.Root.dir, filepath.FromSlash(m.Name))
+}
+
+// PerFile returns a copy of the finder which resolves every file
+// independently: the first root having the file wins.
+func (f Finder) PerFile() Finder {
+	f.perFile = true
+	return f
+}
+
+// FindEach resolves every file independently and reports the root of each.
+func (f Finder) FindEach(files ...string) ([]Match, error) {
+	names, err := slashNames(files)
+	if err != nil {
+		return nil, err
+	}
+	var matches []Match
+	var missing []string
+	var probes []Probe
+	for i, name := range names {
+		m, rejected := f.findOne(name)
+		if rejected != nil {
+			missing = append(missing, files[i])
+			probes = append(probes, rejected...)
+			continue
+		}
+		matches = append(matches, m)
+	}
+	if len(missing) > 0 {
+		return nil, &NotFoundError{missing, probes}
+	}
+	return matches, nil
+}
+
+// findOne returns the match of the slash-separated name, or the rejected
+// probes if it is not found.
+func (f Finder) findOne(name string) (Match, []Probe) {
+	var probes []Probe
+	for i, root := range f.roots {
+		if root.err != nil {
+			probes = append(probes, Probe{root, "", ReasonUnavailable, root.err})
+			continue
+		}
+		p, ok := probe(root, filepath.FromSlash(name))
+		if ok {
+			return Match{Name: name, Root: root, index: i}, nil
+		}
+		probes = append(probes, p)
+	}
+	return Match{}, probes
+}
+
+// Glob returns the files matching any of the slash-separated patterns
+// in all roots, sorted by name.  Besides the syntax of path.Match,
+// a "**" element matches any number of directories.  A file found in
+// several roots is reported for the first one only.
+func (f Finder) Glob(patterns ...string) ([]Match, error) {
+	seen := make(map[string]bool)
+	var matches []Match
+	for _, pattern := range patterns {
+		pattern = path.Clean(filepath.ToSlash(pattern))
+		if !fs.ValidPath(pattern) {
+			return nil, fmt.Errorf("pattern %q is not a valid relative path", pattern)
+		}
+		if err := validGlob(pattern); err != nil {
+			return nil, err
+		}
+		dir := globDir(pattern)
+		for i, root := range f.roots {
+			if root.err != nil {
+				continue
+			}
+			err := fs.WalkDir(root.fsys, dir, func(name string, d fs.DirEntry, err error) error {
+				if err != nil {
+					// Fall through to the next root like Find does.
+					if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+						return fs.SkipDir
+					}
+					return err
+				}
+				if d.IsDir() || seen[name] {
+					return nil
+				}
+				if ok, _ := matchGlob(pattern, name); ok {
+					seen[name] = true
+					matches = append(matches, Match{Name: name, Root: root, index: i})
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
+	return matches, nil
+}
+
+// globDir returns the longest directory of pattern without meta characters.
+func globDir(pattern string) string {
+	elems := strings.Split(pattern, "/")
+	var dir []string
+	for _, e := range elems[:len(elems)-1] {
+		if strings.ContainsAny(e, `*?[\`) {
+			break
+		}
+		dir = append(dir, e)
+	}
+	if len(dir) == 0 {
+		return "."
+	}
+	return path.Join(dir...)
+}
+
+// validGlob returns path.ErrBadPattern if pattern is malformed.
+func validGlob(pattern string) error {
+	for _, e := range strings.Split(pattern, "/") {
+		if _, err := path.Match(e, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether name matches pattern, see Glob.
+func matchGlob(pattern, name string) (bool, error) {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if ok, err := matchElems(pattern[1:], name[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(name) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false, err
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// matchFS is the file system of the files found in different roots.
+type matchFS map[string]Root
+
+func (m matchFS) Open(name string) (fs.File, error) {
+	root, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return root.fsys.Open(name)
+}
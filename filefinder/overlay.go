@@ -0,0 +1,142 @@
+package filefinder
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"syscall"
+)
+
+// Overlay returns the file system merging all roots: a file in an earlier
+// root shadows the same file in the later ones, a directory lists the
+// union of the directories with its name.  Use http.FS to serve it.
+func (f Finder) Overlay() fs.FS {
+	return overlayFS{f.roots}
+}
+
+type overlayFS struct {
+	roots []Root
+}
+
+// skip reports whether the error lets the next root be tried.
+// A file on the path, e.g. "static" for "static/app.js", hides nothing.
+func skip(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.ENOTDIR)
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for i, root := range o.roots {
+		if root.err != nil {
+			continue
+		}
+		file, err := root.fsys.Open(name)
+		if skip(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fi, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return file, nil
+		}
+		entries, err := o.readDir(name, o.roots[i:])
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &overlayDir{File: file, entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir returns the union of the directory name in all roots.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	for i, root := range o.roots {
+		if root.err != nil {
+			continue
+		}
+		fi, err := fs.Stat(root.fsys, name)
+		if skip(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+		}
+		return o.readDir(name, o.roots[i:])
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+}
+
+// readDir merges the directory name of roots sorted by name, the entries
+// of the earlier roots win.
+func (o overlayFS) readDir(name string, roots []Root) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for _, root := range roots {
+		if root.err != nil {
+			continue
+		}
+		list, err := fs.ReadDir(root.fsys, name)
+		if skip(err) {
+			continue
+		}
+		if err != nil {
+			// The name is not a directory in this root.
+			if fi, serr := fs.Stat(root.fsys, name); serr == nil && !fi.IsDir() {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range list {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// overlayDir is a merged directory, Stat and Close go to the directory
+// of the first root.
+type overlayDir struct {
+	fs.File
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *overlayDir) Read([]byte) (int, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
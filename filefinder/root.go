@@ -0,0 +1,111 @@
+package filefinder
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Root is a place to search files in: an OS directory or an fs.FS,
+// e.g. an embed.FS.
+type Root struct {
+	name string
+	dir  string // the OS directory, empty if the root is not on disk
+	fsys fs.FS
+	err  error // why the root is unavailable, it is skipped if not nil
+}
+
+// Dir returns the root of the OS directory path.
+func Dir(path string) Root {
+	path = filepath.Clean(path)
+	return Root{name: path, dir: path, fsys: os.DirFS(path)}
+}
+
+// FS returns the root of fsys, the name is used in the messages only.
+func FS(name string, fsys fs.FS) Root {
+	return Root{name: name, fsys: fsys}
+}
+
+// unavailable returns the root which is skipped by the search.
+func unavailable(name string, err error) Root {
+	return Root{name: name, err: err}
+}
+
+// Env returns the root of the directory in the environment variable
+// name, e.g. WEBTESTS_ROOT, joined with elem.  The root is unavailable
+// if the variable is not set.
+func Env(name string, elem ...string) Root {
+	dir := os.Getenv(name)
+	if dir == "" {
+		return unavailable(filepath.Join(append([]string{"$" + name}, elem...)...),
+			fmt.Errorf("$%s is not set", name))
+	}
+	return Dir(filepath.Join(append([]string{dir}, elem...)...))
+}
+
+// Executable returns the root of the directory of the running
+// executable joined with elem.
+func Executable(elem ...string) Root {
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return unavailable(filepath.Join(append([]string{"<executable>"}, elem...)...), err)
+	}
+	return Dir(filepath.Join(append([]string{filepath.Dir(exe)}, elem...)...))
+}
+
+// ModuleRoot returns the root of the nearest directory containing go.mod,
+// starting from the current one, joined with elem.
+func ModuleRoot(elem ...string) Root {
+	wd, err := os.Getwd()
+	if err != nil {
+		return unavailable(filepath.Join(append([]string{"<module>"}, elem...)...), err)
+	}
+	return moduleRoot(wd, elem...)
+}
+
+func moduleRoot(dir string, elem ...string) Root {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return Dir(filepath.Join(append([]string{dir}, elem...)...))
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return unavailable(filepath.Join(append([]string{"<module>"}, elem...)...),
+				errors.New("go.mod is not found"))
+		}
+		dir = parent
+	}
+}
+
+// Err returns why the root is unavailable, or nil.
+func (r Root) Err() error {
+	return r.err
+}
+
+// String returns the name of the root.
+func (r Root) String() string {
+	return r.name
+}
+
+// Path returns the OS directory of the root, or "" if it is not on disk.
+func (r Root) Path() string {
+	return r.dir
+}
+
+// FS returns the file system of the root.
+func (r Root) FS() fs.FS {
+	return r.fsys
+}
+
+// stat stats the file with the cleaned relative OS path name.
+func (r Root) stat(name string) (fs.FileInfo, error) {
+	if r.dir != "" {
+		return os.Stat(filepath.Join(r.dir, name))
+	}
+	return fs.Stat(r.fsys, filepath.ToSlash(name))
+}
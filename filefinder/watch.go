@@ -0,0 +1,186 @@
+package filefinder
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventKind is the kind of a change of a found file.
+type EventKind int
+
+const (
+	Created  EventKind = iota + 1 // the file appeared
+	Modified                      // the file changed, or a shadowing file is gone
+	Removed                       // the file is gone from all roots
+	Shadowed                      // the file appeared in a root of a higher priority
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case Created:
+		return "created"
+	case Modified:
+		return "modified"
+	case Removed:
+		return "removed"
+	case Shadowed:
+		return "shadowed"
+	}
+	return "unknown"
+}
+
+// Event is a change of a found file.
+type Event struct {
+	Name string // slash-separated name of the file
+	Kind EventKind
+	Root Root // the root supplying the file now, or the one it was last found in if removed
+}
+
+// fileState is what is polled to detect a change.
+type fileState struct {
+	match Match
+	mod   time.Time
+	size  int64
+}
+
+// Watcher polls the files matching the patterns of Glob in all roots.
+type Watcher struct {
+	f        Finder
+	patterns []string
+	interval time.Duration
+	debounce time.Duration
+	events   chan Event
+	done     chan struct{}
+	once     sync.Once
+}
+
+// Watch starts polling the files matching the patterns every interval.
+// The events are delivered when no more changes are seen for debounce.
+// The interval must be positive and the debounce must not be negative.
+func (f Finder) Watch(interval, debounce time.Duration, patterns ...string) (*Watcher, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("watch interval %v is not positive", interval)
+	}
+	if debounce < 0 {
+		return nil, fmt.Errorf("watch debounce %v is negative", debounce)
+	}
+	w := &Watcher{
+		f:        f,
+		patterns: patterns,
+		interval: interval,
+		debounce: debounce,
+		events:   make(chan Event),
+		done:     make(chan struct{}),
+	}
+	state, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	go w.run(state)
+	return w, nil
+}
+
+// Events returns the channel of events, it is closed by Close.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+func (w *Watcher) scan() (map[string]fileState, error) {
+	matches, err := w.f.Glob(w.patterns...)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[string]fileState, len(matches))
+	for _, m := range matches {
+		fi, err := fs.Stat(m.Root.fsys, m.Name)
+		if err != nil {
+			// Removed since Glob, the next scan reports it.
+			continue
+		}
+		state[m.Name] = fileState{m, fi.ModTime(), fi.Size()}
+	}
+	return state, nil
+}
+
+// diff returns the changes from old to cur.
+func diff(old, cur map[string]fileState) []Event {
+	var events []Event
+	for name, c := range cur {
+		o, ok := old[name]
+		switch {
+		case !ok:
+			events = append(events, Event{name, Created, c.match.Root})
+		case c.match.index < o.match.index:
+			events = append(events, Event{name, Shadowed, c.match.Root})
+		case c.match.index > o.match.index || !c.mod.Equal(o.mod) || c.size != o.size:
+			events = append(events, Event{name, Modified, c.match.Root})
+		}
+	}
+	for name, o := range old {
+		if _, ok := cur[name]; !ok {
+			events = append(events, Event{name, Removed, o.match.Root})
+		}
+	}
+	return events
+}
+
+func (w *Watcher) run(state map[string]fileState) {
+	defer close(w.events)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	pending := make(map[string]Event)
+	var changed time.Time // of the last change seen
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		cur, err := w.scan()
+		if err != nil {
+			// The patterns are valid, so the error is a transient one.
+			continue
+		}
+		for _, e := range diff(state, cur) {
+			changed = time.Now()
+			if p, ok := pending[e.Name]; ok {
+				switch {
+				case p.Kind == Created && e.Kind == Removed:
+					delete(pending, e.Name)
+					continue
+				case p.Kind == Created:
+					e.Kind = Created
+				case p.Kind == Removed:
+					e.Kind = Modified
+				}
+			}
+			pending[e.Name] = e
+		}
+		state = cur
+		if len(pending) == 0 || time.Since(changed) < w.debounce {
+			continue
+		}
+		var events []Event
+		for _, e := range pending {
+			events = append(events, e)
+		}
+		sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+		for _, e := range events {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+		pending = make(map[string]Event)
+	}
+}
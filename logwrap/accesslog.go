@@ -0,0 +1,109 @@
+package logwrap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AccessLogFormat selects the layout of access log lines.
+type AccessLogFormat int
+
+const (
+	// CommonLog is the Common Log Format:
+	//   host ident authuser [date] "request" status bytes
+	CommonLog AccessLogFormat = iota
+	// CombinedLog is the Common Log Format followed by
+	// the quoted Referer and User-Agent headers.
+	CombinedLog
+)
+
+// clfSink writes one Common or Combined Log Format line per response.
+type clfSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+}
+
+func (s *clfSink) context(ctx context.Context, id string) context.Context {
+	return withRequestID(ctx, id, nil, nil)
+}
+
+func (s *clfSink) request(rec *record) {}
+
+func (s *clfSink) response(rec *record) {
+	r := rec.r
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := ""
+	if r.URL.User != nil {
+		user = r.URL.User.Username()
+	} else if u, _, ok := r.BasicAuth(); ok {
+		user = u
+	}
+	uri := rec.url.RequestURI()
+	size := "-"
+	if rec.rw.bytes > 0 {
+		size = strconv.FormatInt(rec.rw.bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		orDash(host), orDash(clfEscape(user)), rec.start.Format("02/Jan/2006:15:04:05 -0700"),
+		clfQuote(r.Method+" "+uri+" "+r.Proto), rec.rw.Status(), size)
+	if s.format == CombinedLog {
+		line += " " + clfQuote(r.Referer()) + " " + clfQuote(r.UserAgent())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	io.WriteString(s.w, line+"\n")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfQuote returns s in double quotes, escaped the way Apache does it.
+func clfQuote(s string) string {
+	return `"` + clfEscape(s) + `"`
+}
+
+// clfEscape escapes quotes, backslashes and non-printable bytes,
+// so a client cannot forge log lines.
+func clfEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// WithAccessLog makes the handler write Common or Combined Log Format lines to w.
+func WithAccessLog(w io.Writer, f AccessLogFormat) Option {
+	return func(l *logger) {
+		l.out = &clfSink{w: w, format: f}
+	}
+}
+
+// AccessLogHandler returns an http.Handler that writes an access log
+// in the Common or Combined Log Format to w.
+func AccessLogHandler(h http.Handler, w io.Writer, f AccessLogFormat) http.Handler {
+	return New(h, WithAccessLog(w, f))
+}
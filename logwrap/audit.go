@@ -0,0 +1,336 @@
+package logwrap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditBodyLimit is the maximal size of the form parsed for the audit.
+const auditBodyLimit = 64 << 10
+
+// AllFields makes an audit log record all form fields, see NewAuditLog.
+const AllFields = "*"
+
+// zeroHash is the previous hash of the first record.
+var zeroHash = strings.Repeat("0", sha256.Size*2)
+
+// AuditRecord is an entry of the audit log: a state-changing request and its outcome.
+type AuditRecord struct {
+	Seq    uint64            `json:"seq"`
+	Time   time.Time         `json:"time"`
+	ID     string            `json:"id"` // request ID
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Remote string            `json:"remote"`
+	Fields map[string]string `json:"fields,omitempty"` // decoded form fields
+	Status int               `json:"status"`
+	Prev   string            `json:"prev"` // hash of the previous record
+	Hash   string            `json:"hash"` // hash of this record with empty Hash
+}
+
+// hash returns the hash of the record with empty Hash.
+func (rec AuditRecord) hash() string {
+	rec.Hash = ""
+	b, _ := json.Marshal(rec)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditError reports a broken audit log.
+type AuditError struct {
+	Line   int // 1-based
+	Reason string
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("audit log is broken at line %d: %s", e.Line, e.Reason)
+}
+
+// AuditLog is an append-only log of the state-changing requests
+// (POST, PUT, PATCH and DELETE) with their form fields and statuses.
+// Every record contains the hash of the previous one, so that
+// a removed or modified record breaks the chain, see ReadAudit.
+// A log file has a head file next to it with the last seq and hash,
+// so that the removed last records are noticed too, see OpenAuditLog.
+type AuditLog struct {
+	redactor
+	path   string // empty if the log is not a file
+	fields []string
+	log    *log.Logger
+
+	mu   sync.Mutex
+	w    io.Writer
+	seq  uint64
+	last string
+}
+
+// NewAuditLog creates an audit log starting a new chain in w.
+// Only the given form fields are recorded, none if none is given,
+// and all of them with AllFields.  The values of the fields masked
+// in the logs by default, e.g. password, are masked in the records too.
+// The records failed to be written are reported to l, log.Default() if nil.
+func NewAuditLog(w io.Writer, l *log.Logger, fields ...string) *AuditLog {
+	if l == nil {
+		l = log.Default()
+	}
+	return &AuditLog{redactor: defaultRedactor(), w: w, log: l, fields: fields, last: zeroHash}
+}
+
+// OpenAuditLog opens the audit log file, verifies it against its head file,
+// and continues its chain.  See NewAuditLog for l and fields.
+// An unterminated last line left by a crash is removed and reported to l.
+func OpenAuditLog(path string, l *log.Logger, fields ...string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a := NewAuditLog(f, l, fields...)
+	a.path = path
+	if err := a.resume(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// resume reads the records of the file and continues after the last one.
+func (a *AuditLog) resume(f *os.File) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if n := bytes.LastIndexByte(data, '\n') + 1; n < len(data) {
+		if err := f.Truncate(int64(n)); err != nil {
+			return err
+		}
+		a.log.Printf("audit log %s: removed the unterminated last line %q", a.path, data[n:])
+		data = data[:n]
+	}
+	recs, err := ReadAudit(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	seq, hash, err := readHead(a.headPath())
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && len(recs) == 0:
+	case err != nil:
+		return err
+	case seq > uint64(len(recs)):
+		// The record is written before the head, so the log may be
+		// ahead of the head after a crash, but never behind it.
+		return &AuditError{len(recs) + 1, fmt.Sprintf("records %d to %d are missing", len(recs)+1, seq)}
+	case seq > 0 && recs[seq-1].Hash != hash:
+		return &AuditError{int(seq), "hash does not match the head"}
+	}
+	if n := len(recs); n > 0 {
+		a.seq, a.last = recs[n-1].Seq, recs[n-1].Hash
+	}
+	return nil
+}
+
+func (a *AuditLog) headPath() string {
+	return a.path + ".head"
+}
+
+// readHead reads the last seq and hash from the head file.
+func readHead(path string) (uint64, string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", err
+	}
+	var seq uint64
+	var hash string
+	if _, err := fmt.Sscanf(string(b), "%d %s\n", &seq, &hash); err != nil {
+		return 0, "", fmt.Errorf("audit head %s is broken: %v", path, err)
+	}
+	return seq, hash, nil
+}
+
+// writeHead replaces the head file atomically.
+func (a *AuditLog) writeHead() error {
+	path := a.headPath()
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := fmt.Fprintf(f, "%d %s\n", a.seq, a.last); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (a *AuditLog) Close() error {
+	if c, ok := a.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Handler returns an http.Handler that records the state-changing requests served by h.
+// Wrap it with a logging handler to have the request IDs in the records.
+func (a *AuditLog) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			h.ServeHTTP(rw, r)
+			return
+		}
+		rec := AuditRecord{
+			Time:   time.Now().UTC(),
+			ID:     RequestID(r.Context()),
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Remote: r.RemoteAddr,
+			Fields: a.formFields(r),
+		}
+		out, w := wrap(rw)
+		h.ServeHTTP(out, r)
+		rec.Status = w.Status()
+		if err := a.append(rec); err != nil {
+			a.log.Printf("failed to audit req#%s %s %s: %v", rec.ID, rec.Method, rec.Path, err)
+		}
+	})
+}
+
+// formFields decodes the query and the url-encoded body of r
+// without consuming the body.
+func (a *AuditLog) formFields(r *http.Request) map[string]string {
+	vals := r.URL.Query()
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		body, _, _ := peekBody(r, auditBodyLimit)
+		if bv, err := url.ParseQuery(string(body)); err == nil {
+			for k, v := range bv {
+				vals[k] = append(v, vals[k]...)
+			}
+		}
+	}
+	fields := make(map[string]string)
+	for k, v := range vals {
+		switch {
+		case !a.recorded(k):
+		case a.redactsField(k):
+			fields[k] = redacted
+		default:
+			fields[k] = v[0]
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+func (a *AuditLog) recorded(field string) bool {
+	for _, f := range a.fields {
+		if f == field || f == AllFields {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AuditLog) append(rec AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rec.Seq = a.seq + 1
+	rec.Prev = a.last
+	rec.Hash = rec.hash()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	a.seq, a.last = rec.Seq, rec.Hash
+	if a.path == "" {
+		return nil
+	}
+	return a.writeHead()
+}
+
+// ReadAudit reads the audit log and verifies its chain.
+// It returns an *AuditError if any record has been changed, removed or reordered.
+func ReadAudit(r io.Reader) ([]AuditRecord, error) {
+	var recs []AuditRecord
+	prev := zeroHash
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return recs, &AuditError{line, err.Error()}
+		}
+		switch {
+		case rec.Seq != uint64(len(recs)+1):
+			return recs, &AuditError{line, fmt.Sprintf("got seq %d, want %d", rec.Seq, len(recs)+1)}
+		case rec.Prev != prev:
+			return recs, &AuditError{line, "previous hash does not match"}
+		case rec.Hash != rec.hash():
+			return recs, &AuditError{line, "hash does not match"}
+		}
+		prev = rec.Hash
+		recs = append(recs, rec)
+	}
+	return recs, sc.Err()
+}
+
+// QueryAudit returns the records of the verified audit log
+// with the form field set to value, e.g. all requests of a player ID.
+func QueryAudit(r io.Reader, field, value string) ([]AuditRecord, error) {
+	recs, err := ReadAudit(r)
+	if err != nil {
+		return nil, err
+	}
+	var found []AuditRecord
+	for _, rec := range recs {
+		if v, ok := rec.Fields[field]; ok && v == value {
+			found = append(found, rec)
+		}
+	}
+	return found, nil
+}
+
+// ServeHTTP serves the records of the audit file with ?field=NAME&value=VALUE as JSON.
+// It is meant to be mounted at /debug/audit.
+func (a *AuditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.path == "" {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(a.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	recs, err := QueryAudit(f, r.FormValue("field"), r.FormValue("value"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
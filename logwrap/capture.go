@@ -0,0 +1,218 @@
+package logwrap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// defaultBodyLimit is the number of request body bytes dumped in verbose mode.
+const defaultBodyLimit = 4 << 10
+
+// redacted replaces the values of sensitive headers and form fields.
+const redacted = "REDACTED"
+
+var (
+	defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+	defaultRedactFields  = []string{"password"}
+)
+
+// redactor masks the values of sensitive headers and form fields.
+type redactor struct {
+	redactHeaders []string
+	redactFields  []string
+}
+
+func defaultRedactor() redactor {
+	return redactor{defaultRedactHeaders, defaultRedactFields}
+}
+
+// redactorOf returns the redactor configured by the logging options,
+// the other options are ignored.
+func redactorOf(opts []Option) redactor {
+	l := &logger{redactor: defaultRedactor()}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l.redactor
+}
+
+// WithBodyLimit sets the number of request body bytes dumped in verbose mode.
+// The default is 4KiB, 0 disables the dump of the body.
+// The handler still gets the whole body.
+func WithBodyLimit(limit int64) Option {
+	return func(w *logger) {
+		w.reqBodyLimit = limit
+	}
+}
+
+// WithResponseBody makes the verbose mode dump the response headers
+// and up to limit bytes of the response body.
+func WithResponseBody(limit int64) Option {
+	return func(w *logger) {
+		w.rspBodyLimit = limit
+	}
+}
+
+// WithRedactHeaders sets the headers whose values are masked in the dumps.
+// It replaces the default list: Authorization, Proxy-Authorization, Cookie and Set-Cookie.
+func WithRedactHeaders(names ...string) Option {
+	return func(w *logger) {
+		w.redactHeaders = names
+	}
+}
+
+// WithRedactFields sets the form fields whose values are masked in the logs,
+// both in the URL query and in the url-encoded bodies.
+// It replaces the default list, which is just "password".
+func WithRedactFields(names ...string) Option {
+	return func(w *logger) {
+		w.redactFields = names
+	}
+}
+
+// readCloser joins the reader of the body with its original closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// peekBody reads up to limit bytes of the body of r and puts them back,
+// so that the handler still reads the whole body.
+// It reports if there is more than limit bytes in the body.
+func peekBody(r *http.Request, limit int64) ([]byte, bool, error) {
+	if r.Body == nil || r.Body == http.NoBody || limit <= 0 {
+		return nil, false, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	more := int64(len(buf)) > limit
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	if more {
+		buf = buf[:limit]
+	}
+	return buf, more, err
+}
+
+// limitedBuffer keeps up to limit bytes written to it and drops the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+// Write is an implementation of io.Writer.  It never fails.
+// A buffer with a limit of 0 or less keeps nothing.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	room := b.limit - int64(b.Len())
+	if room < 0 {
+		room = 0
+	}
+	if int64(n) > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.Buffer.Write(p)
+	return n, nil
+}
+
+// dumpRequest returns the redacted request headers and the beginning of the body.
+func (w *logger) dumpRequest(r *http.Request) ([]byte, error) {
+	body, more, err := peekBody(r, w.reqBodyLimit)
+	if err != nil {
+		return nil, err
+	}
+	c := r.Clone(r.Context())
+	c.Body = http.NoBody
+	w.redactHeader(c.Header)
+	c.URL.RawQuery = w.redactForm(c.URL.RawQuery)
+	if c.RequestURI != "" {
+		c.RequestURI = c.URL.RequestURI()
+	}
+	dump, err := httputil.DumpRequest(c, false)
+	if err != nil {
+		return nil, err
+	}
+	return appendBody(dump, w.redactBody(r.Header, body), more), nil
+}
+
+// dumpResponse returns the redacted response headers and the captured body.
+func (w *logger) dumpResponse(r *http.Request, rw *rwWrap) []byte {
+	var b bytes.Buffer
+	status := rw.Status()
+	fmt.Fprintf(&b, "%s %03d %s\r\n", r.Proto, status, http.StatusText(status))
+	h := rw.Header().Clone()
+	w.redactHeader(h)
+	h.Write(&b)
+	b.WriteString("\r\n")
+	return appendBody(b.Bytes(), w.redactBody(h, rw.body.Bytes()), rw.body.truncated)
+}
+
+func appendBody(dump, body []byte, more bool) []byte {
+	dump = append(dump, body...)
+	if more {
+		dump = append(dump, "\n... (truncated)"...)
+	}
+	return dump
+}
+
+func (w *redactor) redactHeader(h http.Header) {
+	for _, name := range w.redactHeaders {
+		name = http.CanonicalHeaderKey(name)
+		for i := range h[name] {
+			h[name][i] = redacted
+		}
+	}
+}
+
+// redactBody masks the form fields in url-encoded bodies.
+func (w *redactor) redactBody(h http.Header, body []byte) []byte {
+	ct := h.Get("Content-Type")
+	if !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+		return body
+	}
+	return []byte(w.redactForm(string(body)))
+}
+
+// redactForm masks the values of the fields in the url-encoded form s,
+// keeping the order of the fields and the encoding of the rest.
+func (w *redactor) redactForm(s string) string {
+	if s == "" || len(w.redactFields) == 0 {
+		return s
+	}
+	pairs := strings.Split(s, "&")
+	for i, pair := range pairs {
+		key, _, found := strings.Cut(pair, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if found && w.redactsField(key) {
+			pairs[i] = pair[:strings.IndexByte(pair, '=')+1] + redacted
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactsHeader reports whether the values of the header name are masked.
+func (w *redactor) redactsHeader(name string) bool {
+	for _, n := range w.redactHeaders {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactsField reports whether the values of the form field name are masked.
+func (w *redactor) redactsField(name string) bool {
+	for _, n := range w.redactFields {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
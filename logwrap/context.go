@@ -0,0 +1,84 @@
+package logwrap
+
+import (
+	"context"
+	"log"
+	"log/slog"
+)
+
+// RequestIDHeader is the header carrying the request ID.
+// An incoming value is used as the ID of the request,
+// and the ID is always sent back in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen limits the length of the request ID accepted from a client.
+const maxRequestIDLen = 128
+
+type ctxKey struct{}
+
+// ctxValue is stored in the request context by the logging handlers.
+type ctxValue struct {
+	id   string
+	log  *log.Logger  // logger of the handler, or nil
+	slog *slog.Logger // structured logger of the handler, or nil
+}
+
+func withRequestID(ctx context.Context, id string, l *log.Logger, sl *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &ctxValue{id, l, sl})
+}
+
+// RequestID returns the ID of the request served with ctx,
+// or "" if the request does not pass through a logging handler.
+func RequestID(ctx context.Context) string {
+	if v, ok := ctx.Value(ctxKey{}).(*ctxValue); ok {
+		return v.id
+	}
+	return ""
+}
+
+// Logger returns a logger that starts every message with "req#ID ",
+// so that the lines logged by a handler can be matched with the request.
+// It writes to the logger of the logging handler if that has one,
+// otherwise to log.Default().
+// Outside of a logging handler it returns log.Default().
+func Logger(ctx context.Context) *log.Logger {
+	v, ok := ctx.Value(ctxKey{}).(*ctxValue)
+	if !ok {
+		return log.Default()
+	}
+	base := v.log
+	if base == nil {
+		base = log.Default()
+	}
+	return log.New(base.Writer(), base.Prefix()+"req#"+v.id+" ", base.Flags()|log.Lmsgprefix)
+}
+
+// Slog returns a structured logger with the "id" attribute set to the request ID.
+// It uses the logger of the logging handler if that has one,
+// otherwise slog.Default().
+// Outside of a logging handler it returns slog.Default().
+func Slog(ctx context.Context) *slog.Logger {
+	v, ok := ctx.Value(ctxKey{}).(*ctxValue)
+	if !ok {
+		return slog.Default()
+	}
+	base := v.slog
+	if base == nil {
+		base = slog.Default()
+	}
+	return base.With(slog.String("id", v.id))
+}
+
+// validRequestID reports if id is acceptable as a request ID sent by a client.
+// It should be short and printable, so that it cannot break the log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package logwrap
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// WithExcludePrefix stops logging the successful requests
+// whose path starts with any of prefixes, e.g. "/static/".
+// The responses with status >= 400 are logged anyway.
+func WithExcludePrefix(prefixes ...string) Option {
+	return func(w *logger) {
+		w.excludePrefixes = append(w.excludePrefixes, prefixes...)
+	}
+}
+
+// WithExcludeRegexp stops logging the successful requests whose path matches re,
+// e.g. `\.(wasm|ico)$`.
+// The responses with status >= 400 are logged anyway.
+func WithExcludeRegexp(re *regexp.Regexp) Option {
+	return func(w *logger) {
+		w.excludeRes = append(w.excludeRes, re)
+	}
+}
+
+// WithSampleRate logs only the given fraction (0..1) of the successful requests.
+// The responses with status >= 400 are logged anyway.
+func WithSampleRate(rate float64) Option {
+	return func(w *logger) {
+		w.sampleRate = rate
+	}
+}
+
+// quiet reports if the request should only be logged if it fails.
+func (w *logger) quiet(r *http.Request) bool {
+	for _, prefix := range w.excludePrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	for _, re := range w.excludeRes {
+		if re.MatchString(r.URL.Path) {
+			return true
+		}
+	}
+	return w.sampleRate < 1 && rand.Float64() >= w.sampleRate
+}
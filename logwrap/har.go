@@ -0,0 +1,365 @@
+package logwrap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR is an HTTP Archive 1.2 document, see http://www.softwareishard.com/blog/har-12-spec/.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog is the root of the archive.
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+	Comment string     `json:"comment,omitempty"`
+}
+
+// HARCreator describes the application that created the archive.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is one request with its response.
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ID              string      `json:"_id,omitempty"` // logwrap request ID
+}
+
+// HARRequest describes the request.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARResponse describes the response.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARNameValue is a header, a cookie or a query parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData is the body of the request.
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARContent is the body of the response.
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings splits the time of the entry into phases, in milliseconds.
+// Only the server side is known, so everything is in Wait.
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+const (
+	// harSaveInterval is how often a recorder saves the new entries.
+	harSaveInterval = time.Second
+	// harMaxEntries is how many of the latest entries a recorder keeps.
+	harMaxEntries = 10000
+)
+
+// HARRecorder records the traffic through its handlers into an HTTP Archive.
+type HARRecorder struct {
+	redactor
+	saveMu  sync.Mutex // orders the saves, so the last one has all entries
+	mu      sync.Mutex
+	path    string
+	limit   int64
+	max     int // of the entries
+	har     HAR
+	dropped int   // number of the oldest entries dropped
+	dirty   bool  // there are entries not saved yet
+	err     error // of the last save
+	done    chan struct{}
+	saved   chan struct{}
+}
+
+// NewHARRecorder creates a recorder that keeps up to limit bytes of every body.
+// The headers and form fields are redacted as in the logs, only the options
+// WithRedactHeaders and WithRedactFields apply, e.g. pass both without names
+// to record everything.
+// If path is not empty, the new entries are saved there every second in
+// background, and on Close.  Only the latest 10000 entries are kept.
+func NewHARRecorder(path string, limit int64, opts ...Option) *HARRecorder {
+	rec := &HARRecorder{
+		redactor: redactorOf(opts),
+		path:     path,
+		limit:    limit,
+		max:      harMaxEntries,
+		har: HAR{HARLog{
+			Version: "1.2",
+			Creator: HARCreator{Name: "logwrap", Version: "1.0"},
+			Entries: []HAREntry{},
+		}},
+		done:  make(chan struct{}),
+		saved: make(chan struct{}),
+	}
+	if path != "" {
+		go rec.run()
+	} else {
+		close(rec.saved)
+	}
+	return rec
+}
+
+// Handler returns an http.Handler that records the traffic through h.
+// Wrap it with a logging handler to have the request IDs in the archive.
+func (rec *HARRecorder) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body, more, _ := peekBody(r, rec.limit)
+		out, w := wrap(rw)
+		w.body = &limitedBuffer{limit: rec.limit}
+		h.ServeHTTP(out, r)
+		dur := time.Since(start)
+		rec.add(rec.harEntry(r, w, start, dur, body, more))
+	})
+}
+
+// WriteTo writes the archive as JSON to w.
+func (rec *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	// The entries are never changed once added, so they are
+	// marshalled without blocking the requests.
+	rec.mu.Lock()
+	har := rec.har
+	rec.mu.Unlock()
+	b, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Err returns the error of the last save, or nil.
+func (rec *HARRecorder) Err() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.err
+}
+
+// Close stops the background saves and saves the new entries.
+// It returns the error of the last save.
+func (rec *HARRecorder) Close() error {
+	select {
+	case <-rec.done:
+	default:
+		close(rec.done)
+	}
+	<-rec.saved
+	return rec.Err()
+}
+
+func (rec *HARRecorder) add(e HAREntry) {
+	rec.mu.Lock()
+	entries := rec.har.Log.Entries
+	if len(entries) >= rec.max {
+		// Drop a tenth at once to copy less often.  The entries are
+		// copied, the old ones may still be marshalled by WriteTo.
+		drop := len(entries) - rec.max + 1 + rec.max/10
+		if drop > len(entries) {
+			drop = len(entries)
+		}
+		entries = append([]HAREntry{}, entries[drop:]...)
+		rec.dropped += drop
+		rec.har.Log.Comment = fmt.Sprintf("%d oldest entries dropped", rec.dropped)
+	}
+	rec.har.Log.Entries = append(entries, e)
+	rec.dirty = true
+	rec.mu.Unlock()
+}
+
+func (rec *HARRecorder) run() {
+	defer close(rec.saved)
+	ticker := time.NewTicker(harSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rec.done:
+			rec.saveDirty()
+			return
+		case <-ticker.C:
+			rec.saveDirty()
+		}
+	}
+}
+
+// saveDirty saves the archive if there are new entries.
+func (rec *HARRecorder) saveDirty() {
+	rec.mu.Lock()
+	dirty := rec.dirty
+	rec.dirty = false
+	rec.mu.Unlock()
+	if !dirty {
+		return
+	}
+	err := rec.save()
+	rec.mu.Lock()
+	rec.err = err
+	if err != nil {
+		// Retry on the next tick.
+		rec.dirty = true
+	}
+	rec.mu.Unlock()
+}
+
+// save replaces the file atomically, so that a reader never sees half of it.
+func (rec *HARRecorder) save() error {
+	rec.saveMu.Lock()
+	defer rec.saveMu.Unlock()
+	f, err := os.CreateTemp(filepath.Dir(rec.path), filepath.Base(rec.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := rec.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), rec.path)
+}
+
+func (rec *HARRecorder) harEntry(r *http.Request, w *rwWrap, start time.Time, dur time.Duration, body []byte, more bool) HAREntry {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	ms := float64(dur) / float64(time.Millisecond)
+	reqHeader := r.Header.Clone()
+	rec.redactHeader(reqHeader)
+	rspHeader := w.Header().Clone()
+	rec.redactHeader(rspHeader)
+	u := *r.URL
+	u.RawQuery = rec.redactForm(u.RawQuery)
+	e := HAREntry{
+		StartedDateTime: start,
+		Time:            ms,
+		Request: HARRequest{
+			Method:      r.Method,
+			URL:         scheme + "://" + r.Host + u.RequestURI(),
+			HTTPVersion: r.Proto,
+			Cookies:     rec.harCookies("Cookie", r.Cookies()),
+			Headers:     harHeaders(reqHeader),
+			QueryString: []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    r.ContentLength,
+		},
+		Response: HARResponse{
+			Status:      w.Status(),
+			StatusText:  http.StatusText(w.Status()),
+			HTTPVersion: r.Proto,
+			Cookies:     rec.harCookies("Set-Cookie", (&http.Response{Header: w.Header()}).Cookies()),
+			Headers:     harHeaders(rspHeader),
+			Content:     harContent(w.Header().Get("Content-Type"), rec.redactBody(w.Header(), w.body.Bytes()), w.body.truncated),
+			RedirectURL: w.Header().Get("Location"),
+			HeadersSize: -1,
+			BodySize:    w.bytes,
+		},
+		Timings: HARTimings{Wait: ms},
+		ID:      RequestID(r.Context()),
+	}
+	e.Response.Content.Size = w.bytes
+	for name, values := range u.Query() {
+		for _, v := range values {
+			e.Request.QueryString = append(e.Request.QueryString, HARNameValue{name, v})
+		}
+	}
+	if body != nil {
+		e.Request.PostData = &HARPostData{
+			MimeType: r.Header.Get("Content-Type"),
+			Text:     string(rec.redactBody(r.Header, body)),
+		}
+		if more {
+			e.Request.PostData.Comment = "truncated"
+		}
+	}
+	return e
+}
+
+func harHeaders(h http.Header) []HARNameValue {
+	nv := []HARNameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			nv = append(nv, HARNameValue{name, v})
+		}
+	}
+	return nv
+}
+
+// harCookies lists the cookies of the header, masked if it is redacted.
+func (rec *HARRecorder) harCookies(header string, cookies []*http.Cookie) []HARNameValue {
+	nv := []HARNameValue{}
+	for _, c := range cookies {
+		v := c.Value
+		if rec.redactsHeader(header) {
+			v = redacted
+		}
+		nv = append(nv, HARNameValue{c.Name, v})
+	}
+	return nv
+}
+
+func harContent(mimeType string, body []byte, truncated bool) HARContent {
+	c := HARContent{MimeType: mimeType}
+	if utf8.Valid(body) {
+		c.Text = string(body)
+	} else {
+		c.Text = base64.StdEncoding.EncodeToString(body)
+		c.Encoding = "base64"
+	}
+	if truncated {
+		c.Comment = "truncated"
+	}
+	return c
+}
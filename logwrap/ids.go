@@ -0,0 +1,110 @@
+package logwrap
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IDGenerator makes IDs for requests that do not come with one.
+// It is called concurrently.
+type IDGenerator interface {
+	NewID() string
+}
+
+// defaultIDs is shared by all handlers without WithIDGenerator,
+// so the IDs are unique within the process.
+var defaultIDs IDGenerator = &Counter{}
+
+// Counter generates IDs 0, 1, 2...  It is the default generator.
+// The IDs are only unique within one run of one process.
+type Counter struct {
+	n atomic.Int64
+}
+
+// NewID is an implementation of IDGenerator.
+func (c *Counter) NewID() string {
+	return strconv.FormatInt(c.n.Add(1)-1, 10)
+}
+
+// Prefixed generates IDs like "ka5fjq2wzq-17", i.e. a prefix picked randomly
+// when the generator is created, followed by a counter.
+// The IDs are unique across restarts and across several processes.
+type Prefixed struct {
+	prefix string
+	Counter
+}
+
+// NewPrefixed creates a Prefixed generator with a random prefix.
+func NewPrefixed() *Prefixed {
+	var b [6]byte
+	rand.Read(b[:])
+	prefix := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b[:])
+	return &Prefixed{prefix: strings.ToLower(prefix)}
+}
+
+// NewID is an implementation of IDGenerator.
+func (p *Prefixed) NewID() string {
+	return p.prefix + "-" + p.Counter.NewID()
+}
+
+// crockford is the alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates ULIDs (https://github.com/ulid/spec): 26 characters
+// encoding a millisecond timestamp and 80 random bits.
+// IDs generated within the same millisecond are monotonic,
+// so the IDs sort in the order of the requests.
+type ULID struct {
+	mu      sync.Mutex
+	ms      uint64
+	entropy [10]byte
+}
+
+// NewULID creates a ULID generator.
+func NewULID() *ULID {
+	return &ULID{}
+}
+
+// NewID is an implementation of IDGenerator.
+func (u *ULID) NewID() string {
+	ms := uint64(time.Now().UnixMilli())
+	u.mu.Lock()
+	if ms > u.ms {
+		u.ms = ms
+		rand.Read(u.entropy[:])
+	} else {
+		// The same millisecond, or the clock went back: keep the order.
+		for i := len(u.entropy) - 1; i >= 0; i-- {
+			u.entropy[i]++
+			if u.entropy[i] != 0 {
+				break
+			}
+		}
+	}
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], u.ms<<16)
+	copy(b[6:], u.entropy[:])
+	u.mu.Unlock()
+
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var out [26]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// WithIDGenerator makes the handler use g for new request IDs.
+func WithIDGenerator(g IDGenerator) Option {
+	return func(w *logger) {
+		w.ids = g
+	}
+}
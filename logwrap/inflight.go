@@ -0,0 +1,125 @@
+package logwrap
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InFlight describes a request being served.
+type InFlight struct {
+	ID         string        `json:"id"`
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	RemoteAddr string        `json:"remote_addr"`
+	Start      time.Time     `json:"start"`
+	Age        time.Duration `json:"age"`
+}
+
+// Tracker keeps the table of the requests being served by its handlers,
+// and warns about the requests running for too long.
+// It is also an http.Handler showing the table, see ServeHTTP.
+type Tracker struct {
+	threshold time.Duration
+	log       *log.Logger
+
+	mu   sync.Mutex
+	seq  uint64
+	reqs map[uint64]*InFlight
+}
+
+// NewTracker creates a tracker that logs a warning to l when a request
+// is still running after threshold.  Zero threshold disables the warnings.
+func NewTracker(threshold time.Duration, l *log.Logger) *Tracker {
+	if l == nil {
+		l = log.Default()
+	}
+	return &Tracker{
+		threshold: threshold,
+		log:       l,
+		reqs:      make(map[uint64]*InFlight),
+	}
+}
+
+// Handler returns an http.Handler that tracks the requests served by h.
+// Wrap it with a logging handler to have the request IDs in the table.
+func (t *Tracker) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req := &InFlight{
+			ID:         RequestID(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+			Start:      time.Now(),
+		}
+		t.mu.Lock()
+		t.seq++
+		key := t.seq
+		t.reqs[key] = req
+		t.mu.Unlock()
+		if t.threshold > 0 {
+			timer := time.AfterFunc(t.threshold, func() {
+				t.log.Printf("slow req#%s %s %s from %s is still running after %v", req.ID, req.Method, req.Path, req.RemoteAddr, t.threshold)
+			})
+			defer timer.Stop()
+		}
+		defer func() {
+			t.mu.Lock()
+			delete(t.reqs, key)
+			t.mu.Unlock()
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// Requests returns the requests being served, the oldest first.
+func (t *Tracker) Requests() []InFlight {
+	now := time.Now()
+	t.mu.Lock()
+	reqs := make([]InFlight, 0, len(t.reqs))
+	for _, req := range t.reqs {
+		reqs = append(reqs, *req)
+	}
+	t.mu.Unlock()
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Start.Before(reqs[j].Start) })
+	for i := range reqs {
+		reqs[i].Age = now.Sub(reqs[i].Start)
+	}
+	return reqs
+}
+
+var inFlightTmpl = template.Must(template.New("inflight").Parse(`<!DOCTYPE html>
+<html>
+<head>
+ <meta charset="UTF-8" />
+ <title>Requests in flight</title>
+</head>
+<body><h2>{{len .}} requests in flight</h2>
+<table>
+<tr><th>ID</th><th>Method</th><th>Path</th><th>Remote address</th><th>Start</th><th>Age</th></tr>
+{{- range .}}
+<tr><td>{{.ID}}</td><td>{{.Method}}</td><td>{{.Path}}</td><td>{{.RemoteAddr}}</td><td>{{.Start.Format "15:04:05.000"}}</td><td>{{.Age}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// ServeHTTP shows the requests in flight as an HTML table,
+// or as JSON if asked with ?format=json or "Accept: application/json".
+// It is meant to be mounted at /debug/requests.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqs := t.Requests()
+	if r.FormValue("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reqs)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	inFlightTmpl.Execute(w, reqs)
+}
@@ -1,67 +1,180 @@
 package logwrap
 
 import (
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
+	"regexp"
+	"time"
 )
 
-var reqIDchan = make(chan requestID)
-
-type requestID int64
-
-func reqID() requestID {
-	return <-reqIDchan
-}
-
+// rwWrap records the final status and the size of the response.
 type rwWrap struct {
 	http.ResponseWriter
-	log *log.Logger
-	r   *http.Request
-	id  requestID
+	status int
+	bytes  int64
+	body   *limitedBuffer // captured body, if requested
 }
 
-func init() {
-	go func() {
-		for i := int64(0); ; i++ {
-			reqIDchan <- requestID(i)
-		}
-	}()
+// WriteHeader is an implementation of http.ResponseWriter.
+func (w *rwWrap) WriteHeader(status int) {
+	// Informational 1xx headers may precede the final one.
+	if w.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
 }
 
-// WriteHeader is an implementation of http.ResponseWriter.
-func (r rwWrap) WriteHeader(status int) {
-	r.log.Printf("rsp#%d %d %s %s", r.id, status, r.r.Method, r.r.URL.String())
-	r.ResponseWriter.WriteHeader(status)
+// Write is an implementation of http.ResponseWriter.
+func (w *rwWrap) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	if w.body != nil {
+		w.body.Write(b[:n])
+	}
+	return n, err
+}
+
+// Status returns the status sent to the client.
+// A handler that writes nothing gets an implicit 200 from net/http.
+func (w *rwWrap) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
 }
 
 type logger struct {
 	h       http.Handler
-	log     *log.Logger
+	out     sink
+	ids     IDGenerator
 	verbose bool
+
+	reqBodyLimit int64
+	rspBodyLimit int64
+	redactor
+
+	excludePrefixes []string
+	excludeRes      []*regexp.Regexp
+	sampleRate      float64
+}
+
+// Option configures a logging handler created by New.
+type Option func(*logger)
+
+// WithLogger makes the handler write free-form lines to l.
+func WithLogger(l *log.Logger) Option {
+	return func(w *logger) {
+		w.out = textSink{l}
+	}
+}
+
+// WithSlog makes the handler write structured records to l.
+func WithSlog(l *slog.Logger) Option {
+	return func(w *logger) {
+		w.out = slogSink{l}
+	}
+}
+
+// Verbose makes the handler dump the request headers and the beginning of the body.
+// See WithBodyLimit, WithResponseBody, WithRedactHeaders and WithRedactFields.
+func Verbose() Option {
+	return func(w *logger) {
+		w.verbose = true
+	}
+}
+
+// New returns an http.Handler with a logging decorator configured by opts.
+// Without WithLogger or WithSlog it logs to log.Default().
+func New(h http.Handler, opts ...Option) http.Handler {
+	w := &logger{
+		h:            h,
+		reqBodyLimit: defaultBodyLimit,
+		redactor:     defaultRedactor(),
+		sampleRate:   1,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.out == nil {
+		w.out = textSink{log.Default()}
+	}
+	if w.ids == nil {
+		w.ids = defaultIDs
+	}
+	return w
 }
 
 // ServeHTTP is implementation of net/http.Handler interface.
-func (w logger) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	id := reqID()
-	wrap := rwWrap{rw, w.log, r, id}
-	if w.verbose {
-		if dump, err := httputil.DumpRequest(r, false); err == nil {
-			w.log.Printf("req#%d follows:\n%s", id, dump)
-			w.h.ServeHTTP(wrap, r)
-			return
+func (w *logger) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rec := &record{r: r, start: time.Now()}
+	rec.id = RequestID(r.Context())
+	if rec.id == "" {
+		// Honour the ID set by the client or a proxy in front of us.
+		rec.id = r.Header.Get(RequestIDHeader)
+		if !validRequestID(rec.id) {
+			rec.id = w.ids.NewID()
 		}
+		r = r.WithContext(w.out.context(r.Context(), rec.id))
+		rec.r = r
 	}
-	w.log.Printf("req#%d %s %s %s", id, r.Method, r.Proto, r.URL.String())
-	w.h.ServeHTTP(wrap, r)
+	rw.Header().Set(RequestIDHeader, rec.id)
+	u := *r.URL
+	u.RawQuery = w.redactForm(u.RawQuery)
+	rec.url = &u
+	out, rw2 := wrap(rw)
+	// Quiet requests are only logged when they fail.
+	quiet := w.quiet(r)
+	if w.verbose && !quiet {
+		if dump, err := w.dumpRequest(r); err == nil {
+			rec.dump = dump
+		}
+		if w.rspBodyLimit > 0 {
+			rw2.body = &limitedBuffer{limit: w.rspBodyLimit}
+		}
+	}
+	if !quiet {
+		w.out.request(rec)
+	}
+	w.h.ServeHTTP(out, r)
+	rec.dur = time.Since(rec.start)
+	rec.rw = rw2
+	if quiet && rw2.Status() < http.StatusBadRequest {
+		return
+	}
+	if rw2.body != nil {
+		rec.rspDump = w.dumpResponse(r, rw2)
+	}
+	w.out.response(rec)
 }
 
 // Handler returns an http.Handler with a logging decorator.
+// It logs a line when the request arrives and another one with the status,
+// the number of body bytes written and the handler duration when it is served.
 func Handler(h http.Handler, l *log.Logger) http.Handler {
-	return logger{h, l, false}
+	return New(h, WithLogger(l))
 }
 
 // VerboseHandler returns a http.Handler with verbose logging decorator.
 func VerboseHandler(h http.Handler, l *log.Logger) http.Handler {
-	return logger{h, l, true}
+	return New(h, WithLogger(l), Verbose())
+}
+
+// SlogHandler returns an http.Handler with a structured logging decorator.
+func SlogHandler(h http.Handler, l *slog.Logger) http.Handler {
+	return New(h, WithSlog(l))
+}
+
+// JSONHandler returns an http.Handler that writes JSON records to w.
+func JSONHandler(h http.Handler, w io.Writer) http.Handler {
+	return SlogHandler(h, slog.New(slog.NewJSONHandler(w, nil)))
+}
+
+// TextHandler returns an http.Handler that writes key=value records to w.
+func TextHandler(h http.Handler, w io.Writer) http.Handler {
+	return SlogHandler(h, slog.New(slog.NewTextHandler(w, nil)))
 }
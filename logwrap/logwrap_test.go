@@ -0,0 +1,1041 @@
+package logwrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompletionRecord(t *testing.T) {
+	tests := []struct {
+		desc    string
+		h       http.HandlerFunc
+		verbose bool
+		want    string
+	}{
+		{
+			desc: "implicit 200",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+			},
+			want: `rsp#\d+ 200 GET /x 5B \S+`,
+		},
+		{
+			desc: "no write at all",
+			h:    func(w http.ResponseWriter, r *http.Request) {},
+			want: `rsp#\d+ 200 GET /x 0B \S+`,
+		},
+		{
+			desc: "explicit status",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("nope"))
+				w.WriteHeader(http.StatusOK) // superfluous, ignored
+			},
+			want: `rsp#\d+ 404 GET /x 4B \S+`,
+		},
+		{
+			desc: "informational header first",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusAccepted)
+			},
+			verbose: true,
+			want:    `rsp#\d+ 202 GET /x 0B \S+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			l := log.New(buf, "", 0)
+			h := Handler(tc.h, l)
+			if tc.verbose {
+				h = VerboseHandler(tc.h, l)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+			if !regexp.MustCompile(`(?m)^` + tc.want + `$`).Match(buf.Bytes()) {
+				t.Errorf("got %q, want a line matching %q", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestOptionalInterfaces(t *testing.T) {
+	// httptest.ResponseRecorder is a Flusher only.
+	out, _ := wrap(httptest.NewRecorder())
+	if _, ok := out.(http.Flusher); !ok {
+		t.Errorf("wrapped recorder is not a http.Flusher")
+	}
+	if _, ok := out.(http.Hijacker); ok {
+		t.Errorf("wrapped recorder is a http.Hijacker")
+	}
+	if _, ok := out.(io.ReaderFrom); ok {
+		t.Errorf("wrapped recorder is an io.ReaderFrom")
+	}
+
+	// A real server connection supports hijacking and deadlines.
+	buf := &bytes.Buffer{}
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Errorf("SetWriteDeadline: %v", err)
+		}
+		conn, _, err := rc.Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: close\r\n\r\n")
+	}), log.New(buf, "", 0))
+	done := make(chan bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		close(done)
+	}))
+	defer srv.Close()
+	rsp, err := http.Get(srv.URL + "/ws")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("got status %d, want 101", rsp.StatusCode)
+	}
+	<-done
+	if want := regexp.MustCompile(`(?m)^rsp#\d+ 101 GET /ws `); !want.Match(buf.Bytes()) {
+		t.Errorf("got %q, want a line matching %q", buf.String(), want)
+	}
+}
+
+func TestJSONHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := JSONHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}), buf)
+	r := httptest.NewRequest("GET", "/pot", nil)
+	r.Header.Set("User-Agent", "test/1.0")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	dec := json.NewDecoder(buf)
+	var req, rsp map[string]any
+	if err := dec.Decode(&req); err != nil {
+		t.Fatalf("decoding request record: %v", err)
+	}
+	if err := dec.Decode(&rsp); err != nil {
+		t.Fatalf("decoding response record: %v", err)
+	}
+	if req["msg"] != "request" || req["path"] != "/pot" || req["user_agent"] != "test/1.0" {
+		t.Errorf("bad request record %v", req)
+	}
+	if rsp["msg"] != "response" || rsp["status"] != float64(418) || rsp["bytes"] != float64(15) {
+		t.Errorf("bad response record %v", rsp)
+	}
+	if _, ok := rsp["duration"].(float64); !ok {
+		t.Errorf("no duration in %v", rsp)
+	}
+	if req["id"] != rsp["id"] {
+		t.Errorf("request id %v != response id %v", req["id"], rsp["id"])
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := AccessLogHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}), buf, CombinedLog)
+	r := httptest.NewRequest("GET", "/a?b=c", nil)
+	r.RemoteAddr = "10.1.2.3:4567"
+	r.SetBasicAuth("frank", "secret")
+	r.Header.Set("Referer", "http://example.com/")
+	r.Header.Set("User-Agent", `evil"agent`)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	want := regexp.MustCompile(`^10\.1\.2\.3 - frank \[\d\d/\w{3}/\d{4}:\d\d:\d\d:\d\d [-+]\d{4}\] "GET /a\?b=c HTTP/1\.1" 200 10 "http://example\.com/" "evil\\"agent"\n$`)
+	if !want.Match(buf.Bytes()) {
+		t.Errorf("got %q, want a line matching %q", buf.String(), want)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		desc     string
+		incoming string
+		want     string // regexp
+	}{
+		{
+			desc: "generated",
+			want: `\d+`,
+		},
+		{
+			desc:     "honoured",
+			incoming: "abc-123",
+			want:     `abc-123`,
+		},
+		{
+			desc:     "invalid is replaced",
+			incoming: "abc 123",
+			want:     `\d+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			var seen string
+			h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestID(r.Context())
+				Logger(r.Context()).Printf("inside")
+			}), log.New(buf, "", 0))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.incoming != "" {
+				r.Header.Set(RequestIDHeader, tc.incoming)
+			}
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, r)
+
+			if !regexp.MustCompile(`^` + tc.want + `$`).MatchString(seen) {
+				t.Errorf("got id %q, want %q", seen, tc.want)
+			}
+			if got := rsp.Header().Get(RequestIDHeader); got != seen {
+				t.Errorf("got %s=%q in response, want %q", RequestIDHeader, got, seen)
+			}
+			for _, want := range []string{"req#" + seen + " GET", "req#" + seen + " inside", "rsp#" + seen + " 200"} {
+				if !bytes.Contains(buf.Bytes(), []byte(want)) {
+					t.Errorf("got %q, want it to contain %q", buf.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestIDGenerators(t *testing.T) {
+	tests := []struct {
+		desc string
+		g    IDGenerator
+		want string // regexp
+	}{
+		{
+			desc: "counter",
+			g:    &Counter{},
+			want: `\d+`,
+		},
+		{
+			desc: "prefixed",
+			g:    NewPrefixed(),
+			want: `[a-z2-7]{10}-\d+`,
+		},
+		{
+			desc: "ulid",
+			g:    NewULID(),
+			want: `[0-7][0-9A-HJKMNP-TV-Z]{25}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			re := regexp.MustCompile(`^` + tc.want + `$`)
+			const n = 1000
+			ids := make(chan string, n)
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < n/10; j++ {
+						ids <- tc.g.NewID()
+					}
+				}()
+			}
+			wg.Wait()
+			close(ids)
+			seen := make(map[string]bool)
+			for id := range ids {
+				if !re.MatchString(id) {
+					t.Fatalf("got id %q, want %q", id, tc.want)
+				}
+				if seen[id] {
+					t.Fatalf("id %q is generated twice", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+}
+
+func TestULIDOrder(t *testing.T) {
+	g := NewULID()
+	prev := g.NewID()
+	for i := 0; i < 1000; i++ {
+		id := g.NewID()
+		if id <= prev {
+			t.Fatalf("id %q generated after %q", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		desc   string
+		h      http.HandlerFunc
+		status int
+	}{
+		{
+			desc: "before headers",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			desc: "after headers",
+			h: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+				panic("boom")
+			},
+			status: http.StatusAccepted,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			l := log.New(buf, "", 0)
+			rsp := httptest.NewRecorder()
+			Handler(Recover(tc.h, l), l).ServeHTTP(rsp, httptest.NewRequest("GET", "/crash?password=hunter2", nil))
+			if rsp.Code != tc.status {
+				t.Errorf("got status %d, want %d", rsp.Code, tc.status)
+			}
+			id := rsp.Header().Get(RequestIDHeader)
+			for _, want := range []string{
+				"panic req#" + id + " GET /crash?password=REDACTED: boom\n",
+				"logwrap.TestRecover",
+				fmt.Sprintf("rsp#%s %d GET /crash", id, tc.status),
+			} {
+				if !bytes.Contains(buf.Bytes(), []byte(want)) {
+					t.Errorf("got %q, want it to contain %q", buf.String(), want)
+				}
+			}
+			if bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+				t.Errorf("got the password in %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestVerboseBodies(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var got string
+	h := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r.PostFormValue("nickname") + "/" + r.PostFormValue("password")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t"})
+		w.Write([]byte("welcome, frank"))
+	}), WithLogger(log.New(buf, "", 0)), Verbose(), WithBodyLimit(64), WithResponseBody(7))
+	r := httptest.NewRequest("POST", "/start.html?password=qwerty", strings.NewReader("id=42&nickname=frank&password=hunter2"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Cookie", "session=s3cr3t")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got != "frank/hunter2" {
+		t.Errorf("handler got %q, want the whole body", got)
+	}
+	for _, want := range []string{
+		"POST /start.html?password=REDACTED HTTP/1.1",
+		"Cookie: REDACTED",
+		"id=42&nickname=frank&password=REDACTED",
+		"Set-Cookie: REDACTED",
+		"welcome\n... (truncated)",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Errorf("got %q, want it to contain %q", buf.String(), want)
+		}
+	}
+	for _, secret := range []string{"hunter2", "qwerty", "s3cr3t"} {
+		if bytes.Contains(buf.Bytes(), []byte(secret)) {
+			t.Errorf("got %q, want no %q in it", buf.String(), secret)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		desc   string
+		opts   []Option
+		path   string
+		status int
+		lines  int
+	}{
+		{
+			desc:   "not excluded",
+			opts:   []Option{WithExcludePrefix("/static/")},
+			path:   "/index.html",
+			status: http.StatusOK,
+			lines:  2,
+		},
+		{
+			desc:   "excluded by prefix",
+			opts:   []Option{WithExcludePrefix("/static/")},
+			path:   "/static/a.css",
+			status: http.StatusOK,
+			lines:  0,
+		},
+		{
+			desc:   "excluded by regexp",
+			opts:   []Option{WithExcludeRegexp(regexp.MustCompile(`\.wasm$`))},
+			path:   "/main.wasm",
+			status: http.StatusOK,
+			lines:  0,
+		},
+		{
+			desc:   "excluded but failed",
+			opts:   []Option{WithExcludePrefix("/static/")},
+			path:   "/static/missing.css",
+			status: http.StatusNotFound,
+			lines:  1,
+		},
+		{
+			desc:   "not sampled",
+			opts:   []Option{WithSampleRate(0)},
+			path:   "/index.html",
+			status: http.StatusOK,
+			lines:  0,
+		},
+		{
+			desc:   "not sampled but failed",
+			opts:   []Option{WithSampleRate(0)},
+			path:   "/index.html",
+			status: http.StatusInternalServerError,
+			lines:  1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			opts := append([]Option{WithLogger(log.New(buf, "", 0))}, tc.opts...)
+			h := New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}), opts...)
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.path, nil))
+			if got := bytes.Count(buf.Bytes(), []byte("\n")); got != tc.lines {
+				t.Errorf("got %d lines %q, want %d", got, buf.String(), tc.lines)
+			}
+		})
+	}
+}
+
+func TestHARRecorder(t *testing.T) {
+	tests := []struct {
+		desc   string
+		opts   []Option
+		body   string
+		cookie string
+	}{
+		{
+			desc:   "redacted",
+			body:   "id=42&nickname=frank&password=REDACTED",
+			cookie: "REDACTED",
+		},
+		{
+			desc:   "not redacted",
+			opts:   []Option{WithRedactHeaders(), WithRedactFields()},
+			body:   "id=42&nickname=frank&password=secret",
+			cookie: "s3cr3t",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.har")
+			rec := NewHARRecorder(path, 1024, tc.opts...)
+			h := Handler(rec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte("<p>ok</p>"))
+			})), log.New(io.Discard, "", 0))
+			r := httptest.NewRequest("POST", "/start.html?x=1", strings.NewReader("id=42&nickname=frank&password=secret"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Cookie", "session=s3cr3t")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if err := rec.Close(); err != nil {
+				t.Fatalf("closing: %v", err)
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading archive: %v", err)
+			}
+			var har HAR
+			if err := json.Unmarshal(b, &har); err != nil {
+				t.Fatalf("decoding archive: %v", err)
+			}
+			if len(har.Log.Entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(har.Log.Entries))
+			}
+			e := har.Log.Entries[0]
+			if e.Request.URL != "http://example.com/start.html?x=1" || e.Request.PostData == nil || e.Request.PostData.Text != tc.body {
+				t.Errorf("bad request %+v", e.Request)
+			}
+			if len(e.Request.Cookies) != 1 || e.Request.Cookies[0].Value != tc.cookie {
+				t.Errorf("got cookies %+v, want %q", e.Request.Cookies, tc.cookie)
+			}
+			for _, nv := range e.Request.Headers {
+				if nv.Name == "Cookie" && nv.Value != "session="+tc.cookie && nv.Value != tc.cookie {
+					t.Errorf("got Cookie header %q", nv.Value)
+				}
+			}
+			if e.Response.Status != 200 || e.Response.Content.Text != "<p>ok</p>" || e.Response.Content.Size != 9 {
+				t.Errorf("bad response %+v", e.Response)
+			}
+			if e.ID == "" {
+				t.Errorf("no request ID in %+v", e)
+			}
+		})
+	}
+}
+
+func TestHARRecorderSaveError(t *testing.T) {
+	rec := NewHARRecorder(filepath.Join(t.TempDir(), "missing", "session.har"), 1024)
+	rec.Handler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if err := rec.Close(); err == nil {
+		t.Error("no error saving into a missing directory")
+	}
+}
+
+func TestHARRecorderLimits(t *testing.T) {
+	rec := NewHARRecorder("", -1)
+	rec.max = 3
+	h := rec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<p>ok</p>"))
+	}))
+	for i := 1; i <= 5; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil))
+	}
+	var buf bytes.Buffer
+	if _, err := rec.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var har HAR
+	if err := json.Unmarshal(buf.Bytes(), &har); err != nil {
+		t.Fatalf("decoding archive: %v", err)
+	}
+	var got []string
+	for _, e := range har.Log.Entries {
+		got = append(got, e.Request.URL)
+		if e.Response.Content.Text != "" || e.Response.Content.Size != 9 {
+			t.Errorf("got content %+v, want no text", e.Response.Content)
+		}
+	}
+	want := []string{"http://example.com/3", "http://example.com/4", "http://example.com/5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+	if har.Log.Comment != "2 oldest entries dropped" {
+		t.Errorf("got comment %q", har.Log.Comment)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	buf := &syncBuffer{}
+	tr := NewTracker(10*time.Millisecond, log.New(buf, "", 0))
+	release := make(chan bool)
+	started := make(chan bool)
+	h := Handler(tr.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})), log.New(io.Discard, "", 0))
+	done := make(chan bool)
+	go func() {
+		r := httptest.NewRequest("POST", "/start.html", nil)
+		r.Header.Set(RequestIDHeader, "stuck")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		close(done)
+	}()
+	<-started
+
+	rsp := httptest.NewRecorder()
+	tr.ServeHTTP(rsp, httptest.NewRequest("GET", "/debug/requests?format=json", nil))
+	var reqs []InFlight
+	if err := json.Unmarshal(rsp.Body.Bytes(), &reqs); err != nil {
+		t.Fatalf("decoding %q: %v", rsp.Body.String(), err)
+	}
+	if len(reqs) != 1 || reqs[0].ID != "stuck" || reqs[0].Path != "/start.html" {
+		t.Errorf("got %+v, want the stuck request", reqs)
+	}
+	rsp = httptest.NewRecorder()
+	tr.ServeHTTP(rsp, httptest.NewRequest("GET", "/debug/requests", nil))
+	if !strings.Contains(rsp.Body.String(), "<td>stuck</td>") {
+		t.Errorf("got %q, want the stuck request in the table", rsp.Body.String())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-done
+	if want := "slow req#stuck POST /start.html"; !strings.Contains(buf.String(), want) {
+		t.Errorf("got %q, want it to contain %q", buf.String(), want)
+	}
+	if reqs := tr.Requests(); len(reqs) != 0 {
+		t.Errorf("got %+v, want no requests in flight", reqs)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/join.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 2000))
+	})
+	h := m.Handler(mux)
+	for _, path := range []string{"/join.html", "/join.html", "/nope"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rsp := httptest.NewRecorder()
+	m.ServeHTTP(rsp, httptest.NewRequest("GET", "/metrics", nil))
+	got := rsp.Body.String()
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/join.html",status="200"} 2`,
+		`http_requests_total{method="GET",route="other",status="404"} 1`,
+		`http_request_duration_seconds_bucket{method="GET",route="/join.html",le="+Inf"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/join.html"} 2`,
+		`http_response_size_bytes_bucket{method="GET",route="/join.html",le="1024"} 0`,
+		`http_response_size_bytes_bucket{method="GET",route="/join.html",le="10240"} 2`,
+		`http_response_size_bytes_sum{method="GET",route="/join.html"} 4000`,
+		`http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("got %s\nwant it to contain %q", got, want)
+		}
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	tests := []struct {
+		desc string
+		in   string
+		ok   bool
+	}{
+		{
+			desc: "valid",
+			in:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			ok:   true,
+		},
+		{
+			desc: "future version with more fields",
+			in:   "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			ok:   true,
+		},
+		{
+			desc: "upper case",
+			in:   "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		},
+		{
+			desc: "non-hex version",
+			in:   "zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			desc: "upper case version",
+			in:   "0A-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			desc: "zero trace id",
+			in:   "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			desc: "short span id",
+			in:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01",
+		},
+		{
+			desc: "empty",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			sc, err := ParseTraceparent(tc.in)
+			if tc.ok != (err == nil) {
+				t.Fatalf("got %v, want %t", err, tc.ok)
+			}
+			if err == nil && tc.in[:2] == "00" && sc.Traceparent() != tc.in {
+				t.Errorf("got %q, want %q", sc.Traceparent(), tc.in)
+			}
+		})
+	}
+}
+
+func TestTracer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	var outgoing http.Header
+	h := NewTracer("test", buf).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = make(http.Header)
+		Inject(r.Context(), outgoing)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	r := httptest.NewRequest("GET", "/proxy", nil)
+	r.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set(TracestateHeader, "vendor=x")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	next, err := ParseTraceparent(outgoing.Get(TraceparentHeader))
+	if err != nil {
+		t.Fatalf("outgoing traceparent: %v", err)
+	}
+	if got := fmt.Sprintf("%x", next.TraceID); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got trace %s, want the incoming one", got)
+	}
+	if outgoing.Get(TracestateHeader) != "vendor=x" {
+		t.Errorf("got tracestate %q, want vendor=x", outgoing.Get(TracestateHeader))
+	}
+
+	var doc struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Kind         int    `json:"kind"`
+					Status       struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	span := doc.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentSpanID != "00f067aa0ba902b7" ||
+		span.SpanID != fmt.Sprintf("%x", next.SpanID) || span.Kind != 2 || span.Status.Code != 2 {
+		t.Errorf("bad span %+v", span)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	rf, err := OpenRotatingFile(path, RotateOptions{MaxSize: 10, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := rf.Write([]byte("0123456789")); err != nil {
+			t.Fatalf("write #%d: %v", i, err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	rotated, _ := filepath.Glob(path + ".*.gz")
+	if len(rotated) != 2 {
+		t.Errorf("got rotated files %v, want 2", rotated)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "0123456789" {
+		t.Errorf("got %q, %v, want the last write only", b, err)
+	}
+	if _, err := rf.Write([]byte("x")); err == nil {
+		t.Errorf("write after close succeeded")
+	}
+}
+
+func TestRotatingFileKeepsAll(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	rf, err := OpenRotatingFile(path, RotateOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := rf.Write([]byte(fmt.Sprintf("%010d", i))); err != nil {
+			t.Fatalf("write #%d: %v", i, err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	files, _ := filepath.Glob(path + "*")
+	var all []string
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, string(b))
+	}
+	sort.Strings(all)
+	if got, want := strings.Join(all, ""), "00000000000000000001000000000200000000030000000004"; got != want {
+		t.Errorf("got %q in %v, want %q", got, files, want)
+	}
+}
+
+func TestRotatingFileRecovers(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "access.log")
+	rf, err := OpenRotatingFile(path, RotateOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer rf.Close()
+	rf.Write([]byte("0123456789"))
+
+	// The rename fails, and so does reopening.
+	os.RemoveAll(dir)
+	if _, err := rf.Write([]byte("lost")); err == nil {
+		t.Errorf("write into a removed directory succeeded")
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("back")); err != nil {
+		t.Fatalf("write after the directory is back: %v", err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "back" {
+		t.Errorf("got %q, %v, want %q", b, err, "back")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := OpenAuditLog(path, nil, "id", "nickname")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("nickname") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	for _, body := range []string{"id=1&nickname=frank", "id=2&nickname=", "id=1&nickname=frank&password=x"} {
+		r := httptest.NewRequest("POST", "/start.html", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/join.html", nil))
+	a.Close()
+
+	// Reopening continues the chain.
+	a, err = OpenAuditLog(path, nil, "id", "nickname")
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	r := httptest.NewRequest("DELETE", "/start.html?id=2", nil)
+	h = a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	a.Close()
+
+	f, _ := os.Open(path)
+	recs, err := QueryAudit(f, "id", "1")
+	f.Close()
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(recs) != 2 || recs[0].Seq != 1 || recs[1].Seq != 3 || recs[0].Fields["nickname"] != "frank" || recs[1].Fields["password"] != "" {
+		t.Errorf("got %+v, want records 1 and 3 without password", recs)
+	}
+
+	b, _ := os.ReadFile(path)
+	if lines := strings.Count(string(b), "\n"); lines != 4 {
+		t.Errorf("got %d records, want 4", lines)
+	}
+	tampered := strings.Replace(string(b), `"nickname":"frank"`, `"nickname":"jane"`, 1)
+	var ae *AuditError
+	if _, err := ReadAudit(strings.NewReader(tampered)); !errors.As(err, &ae) || ae.Line != 1 {
+		t.Errorf("got %v, want an AuditError at line 1", err)
+	}
+	removed := string(b)[strings.Index(string(b), "\n")+1:]
+	if _, err := ReadAudit(strings.NewReader(removed)); !errors.As(err, &ae) || ae.Line != 1 {
+		t.Errorf("got %v, want an AuditError at line 1", err)
+	}
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	join := func(a *AuditLog, id string) {
+		r := httptest.NewRequest("POST", "/start.html?id="+id, nil)
+		a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), r)
+	}
+	tests := []struct {
+		desc   string
+		change func(path string, data []byte)
+		logged string // in the log of the reopened audit log
+		seq    uint64 // of the last record, 0 if it fails to open
+	}{
+		{
+			desc:   "intact",
+			change: func(path string, data []byte) {},
+			seq:    3,
+		},
+		{
+			desc: "last record removed",
+			change: func(path string, data []byte) {
+				data = data[:len(data)-1]
+				os.WriteFile(path, data[:bytes.LastIndexByte(data, '\n')+1], 0644)
+			},
+		},
+		{
+			desc:   "all records removed",
+			change: func(path string, data []byte) { os.WriteFile(path, nil, 0644) },
+		},
+		{
+			desc:   "head removed",
+			change: func(path string, data []byte) { os.Remove(path + ".head") },
+		},
+		{
+			desc: "torn last line",
+			change: func(path string, data []byte) {
+				os.WriteFile(path, append(data, `{"seq":4,"ti`...), 0644)
+			},
+			logged: `removed the unterminated last line "{\"seq\":4,\"ti"`,
+			seq:    3,
+		},
+		{
+			desc: "head changed",
+			change: func(path string, data []byte) {
+				b, _ := os.ReadFile(path + ".head")
+				os.WriteFile(path+".head", append([]byte("2"), b[1:]...), 0644)
+			},
+		},
+		{
+			desc: "crash before the head",
+			change: func(path string, data []byte) {
+				recs, _ := ReadAudit(bytes.NewReader(data))
+				os.WriteFile(path+".head", []byte(fmt.Sprintf("2 %s\n", recs[1].Hash)), 0644)
+			},
+			seq: 3,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			a, err := OpenAuditLog(path, nil, "id")
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			for _, id := range []string{"1", "2", "3"} {
+				join(a, id)
+			}
+			a.Close()
+			data, _ := os.ReadFile(path)
+			tc.change(path, data)
+
+			var buf bytes.Buffer
+			a, err = OpenAuditLog(path, log.New(&buf, "", 0), "id")
+			if tc.seq == 0 {
+				if err == nil {
+					a.Close()
+					t.Fatal("opened, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			join(a, "4")
+			a.Close()
+			if !strings.Contains(buf.String(), tc.logged) {
+				t.Errorf("got log %q, want it to contain %q", buf.String(), tc.logged)
+			}
+			f, _ := os.Open(path)
+			recs, err := ReadAudit(f)
+			f.Close()
+			if err != nil || len(recs) != int(tc.seq)+1 {
+				t.Errorf("got %d records, %v, want %d", len(recs), err, tc.seq+1)
+			}
+		})
+	}
+}
+
+// failingWriter fails all writes.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAuditLogWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	a := NewAuditLog(failingWriter{}, log.New(&buf, "", 0))
+	r := httptest.NewRequest("POST", "/start.html", nil)
+	r.Header.Set(RequestIDHeader, "join")
+	Handler(a.Handler(http.NotFoundHandler()), log.New(io.Discard, "", 0)).ServeHTTP(httptest.NewRecorder(), r)
+	if want := "failed to audit req#join POST /start.html: disk full"; !strings.Contains(buf.String(), want) {
+		t.Errorf("got %q, want it to contain %q", buf.String(), want)
+	}
+}
+
+func TestAuditLogFields(t *testing.T) {
+	tests := []struct {
+		desc   string
+		fields []string
+		want   map[string]string
+	}{
+		{
+			desc: "none",
+		},
+		{
+			desc:   "listed",
+			fields: []string{"nickname", "password"},
+			want:   map[string]string{"nickname": "frank", "password": "REDACTED"},
+		},
+		{
+			desc:   "all",
+			fields: []string{AllFields},
+			want:   map[string]string{"id": "1", "nickname": "frank", "password": "REDACTED"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewAuditLog(&buf, nil, tc.fields...).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest("POST", "/start.html", strings.NewReader("id=1&nickname=frank&password=secret"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			recs, err := ReadAudit(&buf)
+			if err != nil || len(recs) != 1 {
+				t.Fatalf("got %+v, %v, want a record", recs, err)
+			}
+			if !reflect.DeepEqual(recs[0].Fields, tc.want) {
+				t.Errorf("got %v, want %v", recs[0].Fields, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package logwrap
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// durationBuckets are the upper bounds of the latency histogram, in seconds.
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// sizeBuckets are the upper bounds of the response size histogram, in bytes.
+	sizeBuckets = []float64{100, 1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20}
+)
+
+// knownMethods are counted by name, all other methods are counted as "other"
+// to keep the number of series bounded.
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodConnect: true,
+	http.MethodOptions: true, http.MethodTrace: true,
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type requestKey struct {
+	routeKey
+	status int
+}
+
+// Metrics aggregates the requests served by its handlers,
+// and exposes them in the Prometheus text format, see ServeHTTP.
+type Metrics struct {
+	inFlight atomic.Int64
+
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[routeKey]*histogram
+	sizes     map[routeKey]*histogram
+}
+
+// NewMetrics creates an empty set of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[requestKey]uint64),
+		durations: make(map[routeKey]*histogram),
+		sizes:     make(map[routeKey]*histogram),
+	}
+}
+
+// Handler returns an http.Handler that counts the requests served by h.
+// The requests are labelled with the pattern of the http.ServeMux route
+// that served them, or "other" if they did not go through a ServeMux.
+func (m *Metrics) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+		start := time.Now()
+		out, w := wrap(rw)
+		h.ServeHTTP(out, r)
+		dur := time.Since(start)
+
+		method := r.Method
+		if !knownMethods[method] {
+			method = "other"
+		}
+		// ServeMux sets the pattern of the matched route in the request.
+		route := r.Pattern
+		if route == "" {
+			route = "other"
+		}
+		key := routeKey{method, route}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.requests[requestKey{key, w.Status()}]++
+		if m.durations[key] == nil {
+			m.durations[key] = newHistogram(durationBuckets)
+			m.sizes[key] = newHistogram(sizeBuckets)
+		}
+		m.durations[key].observe(dur.Seconds())
+		m.sizes[key].observe(float64(w.bytes))
+	})
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+// It is meant to be mounted at /metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format to w.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.mu.Lock()
+	fmt.Fprintf(&b, "# HELP http_requests_total Total number of served HTTP requests.\n")
+	fmt.Fprintf(&b, "# TYPE http_requests_total counter\n")
+	reqKeys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		reqKeys = append(reqKeys, k)
+	}
+	sort.Slice(reqKeys, func(i, j int) bool {
+		if reqKeys[i].routeKey != reqKeys[j].routeKey {
+			return reqKeys[i].routeKey.less(reqKeys[j].routeKey)
+		}
+		return reqKeys[i].status < reqKeys[j].status
+	})
+	for _, k := range reqKeys {
+		fmt.Fprintf(&b, "http_requests_total{%s,status=\"%d\"} %d\n", k.labels(), k.status, m.requests[k])
+	}
+	writeHistograms(&b, "http_request_duration_seconds", "Time spent serving HTTP requests.", m.durations)
+	writeHistograms(&b, "http_response_size_bytes", "Size of HTTP response bodies.", m.sizes)
+	m.mu.Unlock()
+	fmt.Fprintf(&b, "# HELP http_requests_in_flight Number of HTTP requests being served.\n")
+	fmt.Fprintf(&b, "# TYPE http_requests_in_flight gauge\n")
+	fmt.Fprintf(&b, "http_requests_in_flight %d\n", m.inFlight.Load())
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeHistograms(b *strings.Builder, name, help string, hs map[routeKey]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+	keys := make([]routeKey, 0, len(hs))
+	for k := range hs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	for _, k := range keys {
+		h := hs[k]
+		var cum uint64
+		for i, c := range h.counts {
+			cum += c
+			le := "+Inf"
+			if i < len(h.bounds) {
+				le = strconv.FormatFloat(h.bounds[i], 'f', -1, 64)
+			}
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, k.labels(), le, cum)
+		}
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, k.labels(), formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, k.labels(), h.count)
+	}
+}
+
+func (k routeKey) less(o routeKey) bool {
+	if k.route != o.route {
+		return k.route < o.route
+	}
+	return k.method < o.method
+}
+
+func (k routeKey) labels() string {
+	return fmt.Sprintf("method=\"%s\",route=\"%s\"", escapeLabel(k.method), escapeLabel(k.route))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
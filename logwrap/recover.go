@@ -0,0 +1,54 @@
+package logwrap
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+type recoverer struct {
+	h   http.Handler
+	log *log.Logger
+	redactor
+}
+
+// ServeHTTP is implementation of net/http.Handler interface.
+func (rc recoverer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	out, w := wrap(rw)
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v == http.ErrAbortHandler {
+			// It is the way to abort the response silently.
+			panic(v)
+		}
+		id := RequestID(r.Context())
+		if id == "" {
+			id = "-"
+		}
+		u := *r.URL
+		u.RawQuery = rc.redactForm(u.RawQuery)
+		rc.log.Printf("panic req#%s %s %s: %v\n%s", id, r.Method, u.String(), v, debug.Stack())
+		if w.status == 0 {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}()
+	rc.h.ServeHTTP(out, r)
+}
+
+// Recover returns an http.Handler that recovers from panics in h.
+// A panic is logged to l with the request ID, method, URL and stack trace,
+// and if nothing has been sent yet, the client gets a 500 page.
+// The query is redacted as configured by the logging options opts.
+// Wrap it with a logging handler, so that the request ID is known
+// and the 500 response is logged as well:
+//
+//	logwrap.Handler(logwrap.Recover(mux, hlog), hlog)
+func Recover(h http.Handler, l *log.Logger, opts ...Option) http.Handler {
+	if l == nil {
+		l = log.Default()
+	}
+	return recoverer{h, l, redactorOf(opts)}
+}
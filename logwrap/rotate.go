@@ -0,0 +1,280 @@
+package logwrap
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RotateOptions are the limits of a RotatingFile.
+type RotateOptions struct {
+	MaxSize    int64         // rotate before the file grows over MaxSize bytes, 0 means no limit
+	MaxAge     time.Duration // rotate when the file was opened more than MaxAge ago, 0 means no limit
+	MaxBackups int           // number of rotated files to keep, 0 keeps all of them
+	Compress   bool          // gzip the rotated files
+}
+
+// rotatedSuffix is the time layout of the suffix added to the rotated files.
+const rotatedSuffix = "20060102-150405.000"
+
+// RotatingFile is an io.WriteCloser appending to a file, which is renamed
+// to "NAME.YYYYMMDD-HHMMSS.mmm" and replaced with a new one when it grows too big
+// or too old.  If several files are rotated within a millisecond, "-N" is added
+// to the names of the later ones.  It is safe for concurrent use, e.g. by
+// several loggers.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu     sync.Mutex
+	f      *os.File // nil if closed, or if reopening failed
+	closed bool
+	size   int64
+	opened time.Time
+	bg     sync.WaitGroup // compression and cleanup of rotated files
+	stop   chan os.Signal // set by ReopenOnSignal
+}
+
+// OpenRotatingFile opens path for appending.
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, opts: opts}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, fi.Size(), time.Now()
+	return nil
+}
+
+// Write is an implementation of io.Writer.
+// A single write is never split between files.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.closed {
+		return 0, os.ErrClosed
+	}
+	if rf.f == nil {
+		// The last rotation failed, the file may be back now.
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	tooBig := rf.opts.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.opts.MaxSize
+	tooOld := rf.opts.MaxAge > 0 && time.Since(rf.opened) > rf.opts.MaxAge
+	if tooBig || tooOld {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file now.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotate()
+}
+
+func (rf *RotatingFile) rotate() error {
+	if rf.f == nil {
+		return rf.open()
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	if err != nil {
+		rf.open()
+		return err
+	}
+	rotated := rf.rotatedName(time.Now())
+	if err := os.Rename(rf.path, rotated); err != nil {
+		// Keep appending to the old file.
+		rf.open()
+		return err
+	}
+	if err := rf.open(); err != nil {
+		// Write retries to open it.
+		return err
+	}
+	rf.bg.Add(1)
+	go func() {
+		defer rf.bg.Done()
+		if rf.opts.Compress {
+			compress(rotated)
+		}
+		rf.cleanup()
+	}()
+	return nil
+}
+
+// rotatedName returns a name for the file rotated at t, which is not used
+// by another rotated file, compressed or not.
+func (rf *RotatingFile) rotatedName(t time.Time) string {
+	base := rf.path + "." + t.Format(rotatedSuffix)
+	name := base
+	for seq := 1; exists(name) || exists(name+".gz"); seq++ {
+		name = fmt.Sprintf("%s-%d", base, seq)
+	}
+	return name
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// parseRotated parses the suffix of a rotated file without ".gz".
+func parseRotated(suffix string) (time.Time, int, bool) {
+	seq := 0
+	if i := strings.LastIndexByte(suffix, '-'); i > len("20060102") {
+		n, err := strconv.Atoi(suffix[i+1:])
+		if err != nil || n <= 0 {
+			return time.Time{}, 0, false
+		}
+		suffix, seq = suffix[:i], n
+	}
+	t, err := time.Parse(rotatedSuffix, suffix)
+	return t, seq, err == nil
+}
+
+// compress replaces the file with its gzipped version.
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// cleanup removes the oldest rotated files beyond MaxBackups.
+func (rf *RotatingFile) cleanup() {
+	if rf.opts.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return
+	}
+	type backup struct {
+		name string
+		t    time.Time
+		seq  int
+	}
+	var rotated []backup
+	seen := make(map[string]bool)
+	for _, m := range matches {
+		// A file being compressed is there with and without ".gz".
+		name := strings.TrimSuffix(m, ".gz")
+		if seen[name] {
+			continue
+		}
+		if t, seq, ok := parseRotated(strings.TrimPrefix(name, rf.path+".")); ok {
+			seen[name] = true
+			rotated = append(rotated, backup{name, t, seq})
+		}
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		if !rotated[i].t.Equal(rotated[j].t) {
+			return rotated[i].t.Before(rotated[j].t)
+		}
+		return rotated[i].seq < rotated[j].seq
+	})
+	for len(rotated) > rf.opts.MaxBackups {
+		os.Remove(rotated[0].name)
+		os.Remove(rotated[0].name + ".gz")
+		rotated = rotated[1:]
+	}
+}
+
+// Reopen closes the file and opens it by its name again.
+// It is what is needed after the file is moved away by an external logrotate.
+func (rf *RotatingFile) Reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.closed {
+		return os.ErrClosed
+	}
+	if rf.f != nil {
+		rf.f.Close()
+		rf.f = nil
+	}
+	return rf.open()
+}
+
+// ReopenOnSignal makes the file reopen when the process gets one of sigs,
+// SIGHUP if none is given.
+func (rf *RotatingFile) ReopenOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	rf.mu.Lock()
+	rf.stop = ch
+	rf.mu.Unlock()
+	go func() {
+		for range ch {
+			rf.Reopen()
+		}
+	}()
+}
+
+// Close closes the file and waits for the rotated files to be compressed.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	var err error
+	if rf.f != nil {
+		err = rf.f.Close()
+		rf.f = nil
+	}
+	rf.closed = true
+	if rf.stop != nil {
+		signal.Stop(rf.stop)
+		close(rf.stop)
+		rf.stop = nil
+	}
+	rf.mu.Unlock()
+	rf.bg.Wait()
+	return err
+}
@@ -0,0 +1,104 @@
+package logwrap
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// record describes a request, and after it is served, the response.
+type record struct {
+	id      string
+	r       *http.Request
+	url     *url.URL // URL of the request with redacted query
+	start   time.Time
+	dump    []byte        // verbose dump of the request, if any
+	rspDump []byte        // verbose dump of the response, if any
+	rw      *rwWrap       // set once the request is served
+	dur     time.Duration // time spent in the handler
+}
+
+// sink writes records somewhere.
+type sink interface {
+	context(ctx context.Context, id string) context.Context
+	request(rec *record)
+	response(rec *record)
+}
+
+// textSink writes free-form lines to a log.Logger.
+type textSink struct {
+	log *log.Logger
+}
+
+func (s textSink) context(ctx context.Context, id string) context.Context {
+	return withRequestID(ctx, id, s.log, nil)
+}
+
+func (s textSink) request(rec *record) {
+	r := rec.r
+	if rec.dump != nil {
+		s.log.Printf("req#%s follows:\n%s", rec.id, rec.dump)
+		return
+	}
+	s.log.Printf("req#%s %s %s %s", rec.id, r.Method, r.Proto, rec.url.String())
+}
+
+func (s textSink) response(rec *record) {
+	r := rec.r
+	if rec.rspDump != nil {
+		s.log.Printf("rsp#%s %d %s %s %dB %v follows:\n%s", rec.id, rec.rw.Status(), r.Method, rec.url.String(), rec.rw.bytes, rec.dur, rec.rspDump)
+		return
+	}
+	s.log.Printf("rsp#%s %d %s %s %dB %v", rec.id, rec.rw.Status(), r.Method, rec.url.String(), rec.rw.bytes, rec.dur)
+}
+
+// slogSink writes structured records to a slog.Logger.
+type slogSink struct {
+	log *slog.Logger
+}
+
+func (s slogSink) context(ctx context.Context, id string) context.Context {
+	return withRequestID(ctx, id, nil, s.log)
+}
+
+func (s slogSink) request(rec *record) {
+	r := rec.r
+	attrs := []slog.Attr{
+		slog.String("id", rec.id),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("proto", r.Proto),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("user_agent", r.UserAgent()),
+	}
+	if rec.dump != nil {
+		attrs = append(attrs, slog.String("dump", string(rec.dump)))
+	}
+	s.log.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+}
+
+func (s slogSink) response(rec *record) {
+	r := rec.r
+	level := slog.LevelInfo
+	if rec.rw.Status() >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	attrs := []slog.Attr{
+		slog.String("id", rec.id),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("proto", r.Proto),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.Int("status", rec.rw.Status()),
+		slog.Int64("bytes", rec.rw.bytes),
+		slog.Duration("duration", rec.dur),
+		slog.String("user_agent", r.UserAgent()),
+	}
+	if rec.rspDump != nil {
+		attrs = append(attrs, slog.String("dump", string(rec.rspDump)))
+	}
+	s.log.LogAttrs(r.Context(), level, "response", attrs...)
+}
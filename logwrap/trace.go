@@ -0,0 +1,224 @@
+package logwrap
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// W3C trace-context headers, see https://www.w3.org/TR/trace-context/.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// SpanContext is the part of a span propagated between services.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte   // 1 means sampled
+	State   string // vendor-specific tracestate, passed as is
+}
+
+// ParseTraceparent parses the value of the traceparent header.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("bad traceparent %q", s)
+	}
+	var version, flags [1]byte
+	for _, f := range []struct {
+		dst []byte
+		src string
+	}{{version[:], parts[0]}, {sc.TraceID[:], parts[1]}, {sc.SpanID[:], parts[2]}, {flags[:], parts[3]}} {
+		if len(f.src) != 2*len(f.dst) || strings.ToLower(f.src) != f.src {
+			return sc, fmt.Errorf("bad traceparent %q", s)
+		}
+		if _, err := hex.Decode(f.dst, []byte(f.src)); err != nil {
+			return sc, fmt.Errorf("bad traceparent %q: %v", s, err)
+		}
+	}
+	if sc.TraceID == [16]byte{} || sc.SpanID == [8]byte{} {
+		return sc, fmt.Errorf("bad traceparent %q: zero ID", s)
+	}
+	sc.Flags = flags[0]
+	return sc, nil
+}
+
+// Traceparent returns the value of the traceparent header for sc.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// Span is a server span covering the serving of one request.
+type Span struct {
+	SpanContext
+	ParentID [8]byte // zero if the request did not come with a traceparent
+	Name     string
+	Start    time.Time
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span of the request served with ctx,
+// or nil if the request does not pass through a Tracer handler.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Inject sets the trace-context headers of an outgoing request made
+// while serving the request with ctx, so that the next hop joins the trace.
+func Inject(ctx context.Context, h http.Header) {
+	s := SpanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	h.Set(TraceparentHeader, s.Traceparent())
+	if s.State != "" {
+		h.Set(TracestateHeader, s.State)
+	}
+}
+
+// Transport is an http.RoundTripper that injects the trace-context
+// of the request context into the outgoing requests.
+type Transport struct {
+	Base http.RoundTripper // http.DefaultTransport if nil
+}
+
+// RoundTrip is an implementation of http.RoundTripper.
+func (t Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if SpanFromContext(r.Context()) != nil {
+		r = r.Clone(r.Context())
+		Inject(r.Context(), r.Header)
+	}
+	return base.RoundTrip(r)
+}
+
+// Tracer starts a server span for every request served by its handlers,
+// and writes the finished spans in the OTLP-JSON format, one per line,
+// like the file exporter of OpenTelemetry collector does.
+type Tracer struct {
+	service string
+	mu      sync.Mutex
+	w       io.Writer
+}
+
+// NewTracer creates a tracer writing the spans of the service to w.
+func NewTracer(service string, w io.Writer) *Tracer {
+	return &Tracer{service: service, w: w}
+}
+
+// Handler returns an http.Handler that traces the requests served by h.
+// The span continues the trace of the incoming traceparent header, if any,
+// and is available to h with SpanFromContext.
+func (t *Tracer) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		s := &Span{Start: time.Now()}
+		if parent, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+			s.SpanContext = parent
+			s.ParentID = parent.SpanID
+			s.State = r.Header.Get(TracestateHeader)
+		} else {
+			rand.Read(s.TraceID[:])
+			s.Flags = 1
+		}
+		rand.Read(s.SpanID[:])
+		out, w := wrap(rw)
+		r = r.WithContext(context.WithValue(r.Context(), spanKey{}, s))
+		h.ServeHTTP(out, r)
+		end := time.Now()
+
+		s.Name = r.Method
+		if r.Pattern != "" {
+			s.Name += " " + r.Pattern
+		}
+		attrs := []otlpAttr{
+			stringAttr("http.request.method", r.Method),
+			stringAttr("url.path", r.URL.Path),
+			intAttr("http.response.status_code", int64(w.Status())),
+			stringAttr("client.address", r.RemoteAddr),
+		}
+		if r.Pattern != "" {
+			attrs = append(attrs, stringAttr("http.route", r.Pattern))
+		}
+		if id := RequestID(r.Context()); id != "" {
+			attrs = append(attrs, stringAttr("logwrap.request_id", id))
+		}
+		t.export(s, end, attrs, w.Status())
+	})
+}
+
+// OTLP-JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type otlpAttr struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func stringAttr(key, v string) otlpAttr {
+	return otlpAttr{key, map[string]any{"stringValue": v}}
+}
+
+func intAttr(key string, v int64) otlpAttr {
+	// 64-bit integers are strings in JSON.
+	return otlpAttr{key, map[string]any{"intValue": strconv.FormatInt(v, 10)}}
+}
+
+const (
+	otlpKindServer  = 2
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+func (t *Tracer) export(s *Span, end time.Time, attrs []otlpAttr, status int) {
+	span := map[string]any{
+		"traceId":           hex.EncodeToString(s.TraceID[:]),
+		"spanId":            hex.EncodeToString(s.SpanID[:]),
+		"name":              s.Name,
+		"kind":              otlpKindServer,
+		"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+		"attributes":        attrs,
+		"status":            map[string]any{"code": otlpStatusUnset},
+	}
+	if s.ParentID != [8]byte{} {
+		span["parentSpanId"] = hex.EncodeToString(s.ParentID[:])
+	}
+	if s.State != "" {
+		span["traceState"] = s.State
+	}
+	if status >= http.StatusInternalServerError {
+		span["status"] = map[string]any{"code": otlpStatusError}
+	}
+	doc := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttr{stringAttr("service.name", t.service)},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "logwrap"},
+				"spans": []any{span},
+			}},
+		}},
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(append(b, '\n'))
+}
@@ -0,0 +1,186 @@
+package logwrap
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Unwrap returns the original http.ResponseWriter.
+// It lets http.ResponseController reach the deadlines and other
+// features of the underlying writer.
+func (w *rwWrap) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *rwWrap) flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *rwWrap) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && w.status == 0 {
+		// The connection is taken over, normally to switch protocols.
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *rwWrap) readFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.body != nil {
+		// It disables the sendfile fast path, which is fine for debugging.
+		src = io.TeeReader(src, w.body)
+	}
+	n, err := w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	w.bytes += n
+	return n, err
+}
+
+func (w *rwWrap) push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+type flusher struct{ *rwWrap }
+
+func (f flusher) Flush() { f.flush() }
+
+type hijacker struct{ *rwWrap }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) { return h.hijack() }
+
+type readerFrom struct{ *rwWrap }
+
+func (r readerFrom) ReadFrom(src io.Reader) (int64, error) { return r.readFrom(src) }
+
+type pusher struct{ *rwWrap }
+
+func (p pusher) Push(target string, opts *http.PushOptions) error { return p.push(target, opts) }
+
+// wrap returns a recording wrapper around rw together with
+// an http.ResponseWriter that implements exactly the same optional interfaces
+// (http.Flusher, http.Hijacker, io.ReaderFrom, http.Pusher) as rw does.
+func wrap(rw http.ResponseWriter) (http.ResponseWriter, *rwWrap) {
+	w := &rwWrap{ResponseWriter: rw}
+	const (
+		isFlusher = 1 << iota
+		isHijacker
+		isReaderFrom
+		isPusher
+	)
+	kind := 0
+	if _, ok := rw.(http.Flusher); ok {
+		kind |= isFlusher
+	}
+	if _, ok := rw.(http.Hijacker); ok {
+		kind |= isHijacker
+	}
+	if _, ok := rw.(io.ReaderFrom); ok {
+		kind |= isReaderFrom
+	}
+	if _, ok := rw.(http.Pusher); ok {
+		kind |= isPusher
+	}
+	f, h, r, p := flusher{w}, hijacker{w}, readerFrom{w}, pusher{w}
+	switch kind {
+	case isFlusher:
+		return struct {
+			*rwWrap
+			flusher
+		}{w, f}, w
+	case isHijacker:
+		return struct {
+			*rwWrap
+			hijacker
+		}{w, h}, w
+	case isFlusher | isHijacker:
+		return struct {
+			*rwWrap
+			flusher
+			hijacker
+		}{w, f, h}, w
+	case isReaderFrom:
+		return struct {
+			*rwWrap
+			readerFrom
+		}{w, r}, w
+	case isFlusher | isReaderFrom:
+		return struct {
+			*rwWrap
+			flusher
+			readerFrom
+		}{w, f, r}, w
+	case isHijacker | isReaderFrom:
+		return struct {
+			*rwWrap
+			hijacker
+			readerFrom
+		}{w, h, r}, w
+	case isFlusher | isHijacker | isReaderFrom:
+		return struct {
+			*rwWrap
+			flusher
+			hijacker
+			readerFrom
+		}{w, f, h, r}, w
+	case isPusher:
+		return struct {
+			*rwWrap
+			pusher
+		}{w, p}, w
+	case isFlusher | isPusher:
+		return struct {
+			*rwWrap
+			flusher
+			pusher
+		}{w, f, p}, w
+	case isHijacker | isPusher:
+		return struct {
+			*rwWrap
+			hijacker
+			pusher
+		}{w, h, p}, w
+	case isFlusher | isHijacker | isPusher:
+		return struct {
+			*rwWrap
+			flusher
+			hijacker
+			pusher
+		}{w, f, h, p}, w
+	case isReaderFrom | isPusher:
+		return struct {
+			*rwWrap
+			readerFrom
+			pusher
+		}{w, r, p}, w
+	case isFlusher | isReaderFrom | isPusher:
+		return struct {
+			*rwWrap
+			flusher
+			readerFrom
+			pusher
+		}{w, f, r, p}, w
+	case isHijacker | isReaderFrom | isPusher:
+		return struct {
+			*rwWrap
+			hijacker
+			readerFrom
+			pusher
+		}{w, h, r, p}, w
+	case isFlusher | isHijacker | isReaderFrom | isPusher:
+		return struct {
+			*rwWrap
+			flusher
+			hijacker
+			readerFrom
+			pusher
+		}{w, f, h, r, p}, w
+	}
+	return w, w
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// compressible are the prefixes of the content types worth compressing.
+var compressible = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+var gzipPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// Compress gzips the responses of compressible types
+// to the clients accepting gzip.
+// Range requests, protocol upgrades and event streams are passed as is.
+func Compress() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") ||
+				r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+				h.ServeHTTP(rw, r)
+				return
+			}
+			rw.Header().Add("Vary", "Accept-Encoding")
+			w := &gzipWriter{ResponseWriter: rw}
+			defer w.close()
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// gzipWriter decides to compress when the headers are written.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer // nil if not compressing
+	wroteHeader bool
+}
+
+// WriteHeader is an implementation of http.ResponseWriter.
+func (w *gzipWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= 200 {
+		w.wroteHeader = true
+		w.start(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipWriter) start(status int) {
+	h := w.Header()
+	if status == http.StatusNoContent || status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return
+	}
+	ct := h.Get("Content-Type")
+	if strings.HasPrefix(ct, "text/event-stream") {
+		// The events must reach the client as soon as they are flushed.
+		return
+	}
+	for _, prefix := range compressible {
+		if strings.HasPrefix(ct, prefix) {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			w.gz = gzipPool.Get().(*gzip.Writer)
+			w.gz.Reset(w.ResponseWriter)
+			return
+		}
+	}
+}
+
+// Write is an implementation of http.ResponseWriter.
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			// It is what net/http would do.
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush is an implementation of http.Flusher.
+func (w *gzipWriter) Flush() {
+	if !w.wroteHeader {
+		// Decide on the encoding before the headers go out.
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the original http.ResponseWriter for http.ResponseController.
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipPool.Put(w.gz)
+	w.gz = nil
+}
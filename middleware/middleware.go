@@ -0,0 +1,171 @@
+// Package middleware composes the decorators shared by the servers:
+// logging via logwrap, panic recovery, compression, security headers
+// and cache policy.
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bukind/webtests/logwrap"
+)
+
+// Middleware decorates an http.Handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain is a list of middlewares, the first one is the outermost.
+type Chain []Middleware
+
+// Then returns h decorated with all middlewares of the chain.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
+
+// Config declares the chain of a server.  The zero Config does nothing.
+type Config struct {
+	Log      []logwrap.Option  // options of the logging handler, no logging if nil, see Chain
+	Logger   *log.Logger       // logger of panics, log.Default() if nil
+	Recover  bool              // recover from panics
+	Compress bool              // gzip the compressible responses
+	Security *SecurityHeaders  // security headers to add, none if nil
+	Cache    []CacheRule       // Cache-Control by path, the first matching rule wins
+	Types    map[string]string // Content-Type by file extension, e.g. ".wasm"
+	Inner    []Middleware      // the server's own middlewares, applied inside the others
+}
+
+// Chain returns the middlewares of c in the order they are applied:
+// logging, recovery, security headers, cache policy, content types,
+// compression and then the inner ones.
+// The logging handler sees the compressed responses, so with Compress
+// the response bodies are not dumped, see logwrap.WithResponseBody.
+func (c Config) Chain() Chain {
+	var chain Chain
+	if c.Log != nil {
+		opts := c.Log
+		if c.Compress {
+			opts = append(opts[:len(opts):len(opts)], logwrap.WithResponseBody(0))
+		}
+		chain = append(chain, Logging(opts...))
+	}
+	if c.Recover {
+		chain = append(chain, Recovery(c.Logger, c.Log...))
+	}
+	if c.Security != nil {
+		chain = append(chain, Security(*c.Security))
+	}
+	if len(c.Cache) > 0 {
+		chain = append(chain, Cache(c.Cache...))
+	}
+	if len(c.Types) > 0 {
+		chain = append(chain, ContentTypes(c.Types))
+	}
+	if c.Compress {
+		chain = append(chain, Compress())
+	}
+	return append(chain, c.Inner...)
+}
+
+// Handler returns h decorated with the chain of c.
+func (c Config) Handler(h http.Handler) http.Handler {
+	return c.Chain().Then(h)
+}
+
+// Logging logs the requests with logwrap.
+func Logging(opts ...logwrap.Option) Middleware {
+	return func(h http.Handler) http.Handler {
+		return logwrap.New(h, opts...)
+	}
+}
+
+// Recovery recovers from panics with logwrap.Recover.
+// The logging options opts set the redacted query fields.
+func Recovery(l *log.Logger, opts ...logwrap.Option) Middleware {
+	return func(h http.Handler) http.Handler {
+		return logwrap.Recover(h, l, opts...)
+	}
+}
+
+// SecurityHeaders are the security-related response headers.
+// Empty values are not sent.
+type SecurityHeaders struct {
+	ContentTypeOptions      string // X-Content-Type-Options
+	FrameOptions            string // X-Frame-Options
+	ReferrerPolicy          string // Referrer-Policy
+	ContentSecurityPolicy   string // Content-Security-Policy
+	StrictTransportSecurity string // Strict-Transport-Security, only sent over TLS
+}
+
+// DefaultSecurityHeaders are safe for the servers of this repo.
+var DefaultSecurityHeaders = SecurityHeaders{
+	ContentTypeOptions: "nosniff",
+	FrameOptions:       "DENY",
+	ReferrerPolicy:     "same-origin",
+}
+
+// Security adds the headers of s to every response.
+func Security(s SecurityHeaders) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hdr := w.Header()
+			set := func(name, value string) {
+				if value != "" {
+					hdr.Set(name, value)
+				}
+			}
+			set("X-Content-Type-Options", s.ContentTypeOptions)
+			set("X-Frame-Options", s.FrameOptions)
+			set("Referrer-Policy", s.ReferrerPolicy)
+			set("Content-Security-Policy", s.ContentSecurityPolicy)
+			if r.TLS != nil {
+				set("Strict-Transport-Security", s.StrictTransportSecurity)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CacheRule sets Cache-Control of the responses to the paths
+// with the given prefix and suffix, both are optional.
+type CacheRule struct {
+	Prefix       string
+	Suffix       string
+	CacheControl string
+}
+
+func (c CacheRule) match(p string) bool {
+	return strings.HasPrefix(p, c.Prefix) && strings.HasSuffix(p, c.Suffix)
+}
+
+// Cache sets Cache-Control by the first matching rule.
+// The handler may still override it.
+func Cache(rules ...CacheRule) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, rule := range rules {
+				if rule.match(r.URL.Path) {
+					w.Header().Set("Cache-Control", rule.CacheControl)
+					break
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ContentTypes sets Content-Type by the extension of the path,
+// e.g. {".wasm": "application/wasm"}.  http.FileServer keeps it.
+func ContentTypes(types map[string]string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ct, ok := types[path.Ext(r.URL.Path)]; ok {
+				w.Header().Set("Content-Type", ct)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
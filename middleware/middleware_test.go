@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bukind/webtests/logwrap"
+)
+
+func TestChainOrder(t *testing.T) {
+	var got []string
+	mark := func(name string) Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = append(got, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	Chain{mark("a"), mark("b"), mark("c")}.Then(http.NotFoundHandler()).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if strings.Join(got, "") != "abc" {
+		t.Errorf("got order %v, want a, b, c", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	page := strings.Repeat("<p>Hello, world</p>\n", 100)
+	files := http.FileServer(http.FS(fstest.MapFS{
+		"page.html": {Data: []byte(page)},
+		"main.wasm": {Data: []byte("\x00asm\x01\x00\x00\x00")},
+		"img.png":   {Data: []byte("\x89PNG\r\n\x1a\n")},
+	}))
+	h := Config{
+		Compress: true,
+		Security: &DefaultSecurityHeaders,
+		Cache:    []CacheRule{{Suffix: ".wasm", CacheControl: "no-cache"}},
+		Types:    map[string]string{".wasm": "application/wasm"},
+	}.Handler(files)
+
+	tests := []struct {
+		desc     string
+		path     string
+		encoding string
+		ctype    string
+		cache    string
+	}{
+		{
+			desc:     "compressed page",
+			path:     "/page.html",
+			encoding: "gzip",
+			ctype:    "text/html; charset=utf-8",
+		},
+		{
+			desc:     "wasm",
+			path:     "/main.wasm",
+			encoding: "gzip",
+			ctype:    "application/wasm",
+			cache:    "no-cache",
+		},
+		{
+			desc:  "incompressible image",
+			path:  "/img.png",
+			ctype: "image/png",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.path, nil)
+			r.Header.Set("Accept-Encoding", "gzip, deflate")
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, r)
+			hdr := rsp.Header()
+			if hdr.Get("Content-Encoding") != tc.encoding || hdr.Get("Content-Type") != tc.ctype || hdr.Get("Cache-Control") != tc.cache {
+				t.Errorf("got headers %v", hdr)
+			}
+			if hdr.Get("X-Content-Type-Options") != "nosniff" {
+				t.Errorf("no security headers in %v", hdr)
+			}
+			if tc.encoding == "" {
+				return
+			}
+			if hdr.Get("Content-Length") != "" {
+				t.Errorf("got Content-Length of the uncompressed body")
+			}
+			zr, err := gzip.NewReader(rsp.Body)
+			if err != nil {
+				t.Fatalf("gzip: %v", err)
+			}
+			body, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatalf("gunzip: %v", err)
+			}
+			if tc.path == "/page.html" && string(body) != page {
+				t.Errorf("got body %q, want the page", body)
+			}
+		})
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	tests := []struct {
+		desc     string
+		ctype    string
+		encoding string
+	}{
+		{
+			desc:     "page",
+			ctype:    "text/html; charset=utf-8",
+			encoding: "gzip",
+		},
+		{
+			desc:  "event stream",
+			ctype: "text/event-stream",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			h := Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.ctype)
+				w.(http.Flusher).Flush()
+				io.WriteString(w, "data: hello\n\n")
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, r)
+			res := rsp.Result()
+			if got := res.Header.Get("Content-Encoding"); got != tc.encoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tc.encoding)
+			}
+			body := io.Reader(res.Body)
+			if tc.encoding == "gzip" {
+				zr, err := gzip.NewReader(body)
+				if err != nil {
+					t.Fatalf("gzip: %v", err)
+				}
+				body = zr
+			}
+			got, err := io.ReadAll(body)
+			if err != nil || string(got) != "data: hello\n\n" {
+				t.Errorf("got body %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestConfigLogCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	h := Config{
+		Log:      []logwrap.Option{logwrap.WithLogger(log.New(&buf, "", 0)), logwrap.Verbose(), logwrap.WithResponseBody(1024)},
+		Compress: true,
+	}.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello")
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rsp := httptest.NewRecorder()
+	h.ServeHTTP(rsp, r)
+	if rsp.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got headers %v, want gzip", rsp.Header())
+	}
+	if strings.Contains(buf.String(), "\x1f\x8b") || strings.Contains(buf.String(), "follows:\nHTTP") {
+		t.Errorf("got the gzipped body dumped in %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "rsp#") {
+		t.Errorf("got no response line in %q", buf.String())
+	}
+}
@@ -0,0 +1,171 @@
+// Package server is the bootstrap shared by the servers: listen address,
+// timeouts, optional TLS, a startup banner and a graceful shutdown on
+// SIGINT or SIGTERM.
+package server
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Config declares how to serve.
+type Config struct {
+	Name           string        // name of the server in the banner
+	Addr           string        // address to listen on, e.g. ":9999"
+	ReadTimeout    time.Duration // see http.Server
+	WriteTimeout   time.Duration // see http.Server
+	IdleTimeout    time.Duration // see http.Server
+	MaxHeaderBytes int           // see http.Server
+	CertFile       string        // TLS certificate, plain HTTP if empty
+	KeyFile        string        // TLS key
+	DrainTimeout   time.Duration // how long to wait for the active requests on shutdown
+	Logger         *log.Logger   // logger of the banner and shutdown, log.Default() if nil
+}
+
+// Defaults returns the config used by the servers so far.
+func Defaults(name, addr string) Config {
+	return Config{
+		Name:           name,
+		Addr:           addr,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		IdleTimeout:    time.Minute,
+		MaxHeaderBytes: 1 << 20,
+		DrainTimeout:   10 * time.Second,
+	}
+}
+
+// RegisterFlags registers the flags overriding c in fs, the values of c
+// become the defaults.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on, e.g. :8080")
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", c.ReadTimeout, "timeout of reading a request")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", c.WriteTimeout, "timeout of writing a response")
+	fs.DurationVar(&c.IdleTimeout, "idle-timeout", c.IdleTimeout, "timeout of an idle keep-alive connection")
+	fs.IntVar(&c.MaxHeaderBytes, "max-header-bytes", c.MaxHeaderBytes, "max size of the request headers")
+	fs.StringVar(&c.CertFile, "tls-cert", c.CertFile, "TLS certificate file, serve plain HTTP if empty")
+	fs.StringVar(&c.KeyFile, "tls-key", c.KeyFile, "TLS key file")
+	fs.DurationVar(&c.DrainTimeout, "drain-timeout", c.DrainTimeout, "how long to wait for the active requests on shutdown")
+}
+
+func (c Config) logger() *log.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return log.Default()
+}
+
+func (c Config) tls() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Server returns the http.Server serving h as configured.
+func (c Config) Server(h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           c.Addr,
+		Handler:        h,
+		ReadTimeout:    c.ReadTimeout,
+		WriteTimeout:   c.WriteTimeout,
+		IdleTimeout:    c.IdleTimeout,
+		MaxHeaderBytes: c.MaxHeaderBytes,
+		ErrorLog:       c.Logger,
+	}
+}
+
+// ListenAndServe serves h until SIGINT or SIGTERM, then shuts down
+// gracefully.
+func (c Config) ListenAndServe(h http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return c.Run(ctx, h)
+}
+
+// Run serves h until ctx is done, then shuts down gracefully.
+func (c Config) Run(ctx context.Context, h http.Handler) error {
+	if c.tls() && (c.CertFile == "" || c.KeyFile == "") {
+		return errors.New("server: both the TLS certificate and key are required")
+	}
+	addr := c.Addr
+	if addr == "" {
+		addr = ":http"
+		if c.tls() {
+			addr = ":https"
+		}
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return c.Serve(ctx, l, h)
+}
+
+// Serve serves h on l until ctx is done, then stops accepting and waits
+// up to DrainTimeout for the active requests.  It returns nil after
+// a graceful shutdown.
+func (c Config) Serve(ctx context.Context, l net.Listener, h http.Handler) error {
+	srv := c.Server(h)
+	srv.Addr = l.Addr().String()
+	lg := c.logger()
+	errc := make(chan error, 1)
+	go func() {
+		if c.tls() {
+			errc <- srv.ServeTLS(l, c.CertFile, c.KeyFile)
+		} else {
+			errc <- srv.Serve(l)
+		}
+	}()
+	lg.Printf("Starting %s on %s", c.name(), c.url(l.Addr()))
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	lg.Printf("Shutting down %s, draining for %v", c.name(), c.DrainTimeout)
+	dctx := context.Background()
+	if c.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		dctx, cancel = context.WithTimeout(dctx, c.DrainTimeout)
+		defer cancel()
+	}
+	if err := srv.Shutdown(dctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("server: shutdown: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	lg.Printf("Stopped %s", c.name())
+	return nil
+}
+
+func (c Config) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return "the server"
+}
+
+func (c Config) url(addr net.Addr) string {
+	scheme := "http"
+	if c.tls() {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return scheme + "://" + addr.String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterFlags(t *testing.T) {
+	c := Defaults("test", ":9999")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-addr", ":8080", "-drain-timeout", "3s", "-tls-cert", "c.pem", "-tls-key", "k.pem"}); err != nil {
+		t.Fatal(err)
+	}
+	want := Defaults("test", ":8080")
+	want.DrainTimeout = 3 * time.Second
+	want.CertFile = "c.pem"
+	want.KeyFile = "k.pem"
+	if c != want {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+}
+
+func TestRunNeedsKey(t *testing.T) {
+	c := Defaults("test", "127.0.0.1:0")
+	c.CertFile = "c.pem"
+	if err := c.Run(context.Background(), http.NotFoundHandler()); err == nil {
+		t.Error("no error without the TLS key")
+	}
+}
+
+func TestServeDrains(t *testing.T) {
+	tests := []struct {
+		name    string
+		drain   time.Duration
+		release time.Duration
+		wantErr bool
+	}{
+		{"drained", time.Second, 50 * time.Millisecond, false},
+		{"timeout", 50 * time.Millisecond, time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(tt.release)
+				io.WriteString(w, "done")
+			})
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			c := Defaults("test", "")
+			c.DrainTimeout = tt.drain
+			c.Logger = log.New(&buf, "", 0)
+			ctx, cancel := context.WithCancel(context.Background())
+			served := make(chan error, 1)
+			go func() { served <- c.Serve(ctx, l, h) }()
+
+			got := make(chan string, 1)
+			go func() {
+				rsp, err := http.Get("http://" + l.Addr().String() + "/")
+				if err != nil {
+					got <- err.Error()
+					return
+				}
+				defer rsp.Body.Close()
+				b, _ := io.ReadAll(rsp.Body)
+				got <- string(b)
+			}()
+			<-started
+			cancel()
+			err = <-served
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Serve() = %v, want error %v", err, tt.wantErr)
+			}
+			if body := <-got; !tt.wantErr && body != "done" {
+				t.Errorf("the active request got %q", body)
+			}
+			for _, s := range []string{"Starting test on http://127.0.0.1:", "Shutting down test"} {
+				if !strings.Contains(buf.String(), s) {
+					t.Errorf("log %q lacks %q", buf.String(), s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+// Package tmpl is a registry of html templates found with filefinder.
+// Every page is parsed together with the shared base layout, which is
+// expected to have the blocks "title", "style", "js" and "content"
+// for the page to define.
+package tmpl
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bukind/webtests/filefinder"
+)
+
+// ParseError is a template syntax error.
+type ParseError struct {
+	File string // OS path of the file, or root:name if not on disk
+	Line int    // 0 if unknown
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithBase sets the base layout files, the first one is executed.
+// Without a base the page itself is executed.
+func WithBase(files ...string) Option {
+	return func(r *Registry) {
+		r.base = files
+	}
+}
+
+// WithFuncs adds the functions available to the templates.
+func WithFuncs(funcs template.FuncMap) Option {
+	return func(r *Registry) {
+		for k, v := range funcs {
+			r.funcs[k] = v
+		}
+	}
+}
+
+// Dev re-parses a page when its files change, are shadowed or removed.
+// Otherwise a page is parsed once and cached.
+func Dev(on bool) Option {
+	return func(r *Registry) {
+		r.dev = on
+	}
+}
+
+// Registry parses and caches the pages.  It resolves every file
+// independently, so a page or the base may be overridden alone.
+type Registry struct {
+	f     filefinder.Finder
+	base  []string
+	funcs template.FuncMap
+	dev   bool
+
+	mu    sync.Mutex
+	pages map[string]*page
+}
+
+// page is a parsed page and the state of its files.
+type page struct {
+	t     *template.Template
+	files []fileState
+}
+
+type fileState struct {
+	match filefinder.Match
+	mod   time.Time
+	size  int64
+}
+
+// New creates a registry finding files with f.
+func New(f filefinder.Finder, opts ...Option) *Registry {
+	r := &Registry{
+		f:     f.PerFile(),
+		funcs: make(template.FuncMap),
+		pages: make(map[string]*page),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Lookup returns the parsed page, e.g. "templates/join.html".
+// The files are checked and parsed without holding the lock,
+// so a slow parse does not block the other pages.
+func (r *Registry) Lookup(name string) (*template.Template, error) {
+	r.mu.Lock()
+	p, ok := r.pages[name]
+	r.mu.Unlock()
+	if ok && (!r.dev || !r.changed(name, p)) {
+		return p.t, nil
+	}
+	p, err := r.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.pages[name] = p
+	r.mu.Unlock()
+	return p.t, nil
+}
+
+// Must parses the pages or panics.  It is useful for program initialization.
+func (r *Registry) Must(names ...string) *Registry {
+	for _, name := range names {
+		if _, err := r.Lookup(name); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Execute executes the page with data into w.  Nothing is written
+// if it fails, so the caller may still report an error.
+func (r *Registry) Execute(w io.Writer, name string, data any) error {
+	t, err := r.Lookup(name)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func (r *Registry) files(name string) []string {
+	return append(append([]string(nil), r.base...), name)
+}
+
+func (r *Registry) parse(name string) (*page, error) {
+	matches, err := r.f.FindEach(r.files(name)...)
+	if err != nil {
+		return nil, err
+	}
+	t := template.New(path.Base(matches[0].Name)).Funcs(r.funcs)
+	p := &page{t: t}
+	for _, m := range matches {
+		fsys := m.Root.FS()
+		fi, err := fs.Stat(fsys, m.Name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := fs.ReadFile(fsys, m.Name)
+		if err != nil {
+			return nil, err
+		}
+		tt := t
+		if base := path.Base(m.Name); base != t.Name() {
+			tt = t.New(base)
+		}
+		if _, err := tt.Parse(string(data)); err != nil {
+			return nil, parseError(m, err)
+		}
+		p.files = append(p.files, fileState{m, fi.ModTime(), fi.Size()})
+	}
+	return p, nil
+}
+
+// lineRe finds the line in "template: NAME:LINE: ..." messages.
+var lineRe = regexp.MustCompile(`^template: [^:]*:(\d+):`)
+
+func parseError(m filefinder.Match, err error) *ParseError {
+	file := m.Path()
+	if file == "" {
+		file = m.Root.String() + ":" + m.Name
+	}
+	pe := &ParseError{File: file, Err: err}
+	if s := lineRe.FindStringSubmatch(err.Error()); s != nil {
+		pe.Line, _ = strconv.Atoi(s[1])
+	}
+	return pe
+}
+
+// changed reports whether any file of the page is changed, shadowed
+// or removed since it was parsed.
+func (r *Registry) changed(name string, p *page) bool {
+	matches, err := r.f.FindEach(r.files(name)...)
+	if err != nil {
+		return true
+	}
+	for i, m := range matches {
+		old := p.files[i]
+		if m.Root.String() != old.match.Root.String() {
+			return true
+		}
+		fi, err := fs.Stat(m.Root.FS(), m.Name)
+		if err != nil || !fi.ModTime().Equal(old.mod) || fi.Size() != old.size {
+			return true
+		}
+	}
+	return false
+}
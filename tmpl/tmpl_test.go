@@ -0,0 +1,153 @@
+package tmpl
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/bukind/webtests/filefinder"
+)
+
+const base = `<title>{{block "title" .}}{{end}}</title>{{block "style" .}}{{end}}
+<body>{{block "content" .}}{{end}}</body>{{block "js" .}}{{end}}`
+
+func TestExecute(t *testing.T) {
+	shipped := fstest.MapFS{
+		"templates/base.html":  {Data: []byte(base)},
+		"templates/hello.html": {Data: []byte(`{{define "title"}}Hello{{end}}{{define "content"}}<p>{{.}}</p>{{end}}`)},
+		"templates/plain.html": {Data: []byte(`{{define "content"}}{{upper .}}{{end}}`)},
+		"templates/bad.html":   {Data: []byte("{{define \"content\"}}\n{{.}\n{{end}}")},
+	}
+	local := fstest.MapFS{
+		"templates/plain.html": {Data: []byte(`{{define "title"}}Local{{end}}{{define "content"}}{{.}}{{end}}`)},
+	}
+	r := New(filefinder.NewRoots(filefinder.FS("local", local), filefinder.FS("shipped", shipped)),
+		WithBase("templates/base.html"),
+		WithFuncs(map[string]any{"upper": func(s string) string { return s + "!" }}))
+	tests := []struct {
+		page string
+		data string
+		ok   bool
+		want string
+	}{
+		{
+			page: "templates/hello.html",
+			data: "<world>",
+			ok:   true,
+			want: "<title>Hello</title>\n<body><p>&lt;world&gt;</p></body>",
+		},
+		{
+			page: "templates/plain.html",
+			data: "overridden",
+			ok:   true,
+			want: "<title>Local</title>\n<body>overridden</body>",
+		},
+		{
+			page: "templates/bad.html",
+			ok:   false,
+		},
+		{
+			page: "templates/missing.html",
+			ok:   false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.page, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := r.Execute(&buf, tc.page, tc.data)
+			if tc.ok != (err == nil) {
+				t.Fatalf("got %v, want %t", err, tc.ok)
+			}
+			if !tc.ok && buf.Len() > 0 {
+				t.Errorf("got %q written on error", buf.String())
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	_, err := r.Lookup("templates/bad.html")
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got %T %v, want *ParseError", err, err)
+	}
+	if pe.File != "shipped:templates/bad.html" || pe.Line != 2 {
+		t.Errorf("got %s:%d, want shipped:templates/bad.html:2", pe.File, pe.Line)
+	}
+}
+
+func TestDev(t *testing.T) {
+	local, shipped := t.TempDir(), t.TempDir()
+	write := func(dir, data string) {
+		t.Helper()
+		fp := filepath.Join(dir, "page.html")
+		if err := os.WriteFile(fp, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// The mtime granularity may be too coarse to notice the change.
+		mod := time.Now().Add(time.Duration(len(data)) * time.Second)
+		if err := os.Chtimes(fp, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(shipped, "v1")
+	f := filefinder.NewRoots(filefinder.Dir(local), filefinder.Dir(shipped))
+	tests := []struct {
+		desc   string
+		change func()
+		cached string
+		dev    string
+	}{
+		{"initial", func() {}, "v1", "v1"},
+		{"modified", func() { write(shipped, "v2 changed") }, "v1", "v2 changed"},
+		{"shadowed", func() { write(local, "local") }, "v1", "local"},
+		{"removed", func() { os.Remove(filepath.Join(local, "page.html")) }, "v1", "v2 changed"},
+	}
+	cached, dev := New(f), New(f, Dev(true))
+	for _, tc := range tests {
+		tc.change()
+		for _, r := range []struct {
+			reg  *Registry
+			want string
+		}{{cached, tc.cached}, {dev, tc.dev}} {
+			var buf bytes.Buffer
+			if err := r.reg.Execute(&buf, "page.html", nil); err != nil {
+				t.Fatalf("%s: %v", tc.desc, err)
+			}
+			if buf.String() != r.want {
+				t.Errorf("%s: got %q, want %q (dev %t)", tc.desc, buf.String(), r.want, r.reg.dev)
+			}
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	f := filefinder.NewRoots(filefinder.FS("shipped", fstest.MapFS{
+		"a.html": {Data: []byte("a")},
+		"b.html": {Data: []byte("b")},
+	}))
+	r := New(f, Dev(true))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		name := []string{"a.html", "b.html"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			if err := r.Execute(&buf, name, nil); err != nil {
+				t.Error(err)
+				return
+			}
+			if buf.String() != name[:1] {
+				t.Errorf("%s: got %q", name, buf.String())
+			}
+		}()
+	}
+	wg.Wait()
+}